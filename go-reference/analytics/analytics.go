@@ -0,0 +1,248 @@
+// Package analytics computes per symbol statistics that tell whether a name tends to trend or mean revert
+package analytics
+
+import (
+	"math"
+
+	"github.com/mcmohorn/market/server/data"
+)
+
+const (
+	// DefaultPeriodsPerYear annualizes volatility of daily bars
+	DefaultPeriodsPerYear = 252.0
+	sessionMinutes        = 390 // minutes in a regular session
+	minHurstChunk         = 8   // smallest chunk of returns used in rescaled range analysis
+)
+
+// PeriodsPerYear tells how many daily or minute bars make up a year, for annualizing statistics. Crypto
+// trades every day around the clock.
+func PeriodsPerYear(minute bool, crypto bool) float64 {
+	switch {
+	case crypto && minute:
+		return 365 * 24 * 60
+	case crypto:
+		return 365
+	case minute:
+		return DefaultPeriodsPerYear * sessionMinutes
+	}
+	return DefaultPeriodsPerYear
+}
+
+// CalculateStats computes the statistics of the given bars, annualizing volatilities with periodsPerYear
+func CalculateStats(bars []data.MyBar, periodsPerYear float64) data.SymbolStats {
+	returns := LogReturns(bars)
+
+	stats := data.SymbolStats{
+		Hurst:               Hurst(returns),
+		RealizedVolatility:  RealizedVolatility(returns, periodsPerYear),
+		ParkinsonVolatility: ParkinsonVolatility(bars, periodsPerYear),
+		GarmanKlass:         GarmanKlassVolatility(bars, periodsPerYear),
+		Autocorrelation:     Autocorrelation(returns, 1),
+		Skew:                Skew(returns),
+		Kurtosis:            Kurtosis(returns),
+	}
+	return stats
+}
+
+// LogReturns returns the log return from each bar to the next, skipping bars without a price
+func LogReturns(bars []data.MyBar) []float64 {
+	returns := make([]float64, 0, len(bars))
+	for i := 1; i < len(bars); i++ {
+		if bars[i-1].Price > 0 && bars[i].Price > 0 {
+			returns = append(returns, math.Log(float64(bars[i].Price/bars[i-1].Price)))
+		}
+	}
+	return returns
+}
+
+// Hurst estimates the hurst exponent of the returns with rescaled range analysis.
+// Around 0.5 is a random walk, above trends and below mean reverts.
+func Hurst(returns []float64) float64 {
+	logSizes := make([]float64, 0)
+	logRanges := make([]float64, 0)
+
+	for size := minHurstChunk; size <= len(returns)/2; size *= 2 {
+		total := 0.0
+		chunks := 0
+		for start := 0; start+size <= len(returns); start += size {
+			chunk := returns[start : start+size]
+			mean, std := MeanStd(chunk)
+			if std == 0 {
+				continue
+			}
+
+			// range of the cumulative deviations from the mean
+			cumulative := 0.0
+			high := 0.0
+			low := 0.0
+			for _, r := range chunk {
+				cumulative += r - mean
+				high = math.Max(high, cumulative)
+				low = math.Min(low, cumulative)
+			}
+			total += (high - low) / std
+			chunks++
+		}
+		if chunks > 0 {
+			logSizes = append(logSizes, math.Log(float64(size)))
+			logRanges = append(logRanges, math.Log(total/float64(chunks)))
+		}
+	}
+
+	if len(logSizes) < 2 {
+		return 0.5
+	}
+	hurst, _ := LinearRegression(logSizes, logRanges)
+	return hurst
+}
+
+// PriceLogReturns returns the log return from each price to the next, skipping prices that are not positive
+func PriceLogReturns(prices []float64) []float64 {
+	returns := make([]float64, 0, len(prices))
+	for i := 1; i < len(prices); i++ {
+		if prices[i-1] > 0 && prices[i] > 0 {
+			returns = append(returns, math.Log(prices[i]/prices[i-1]))
+		}
+	}
+	return returns
+}
+
+// RealizedVolatility is the annualized standard deviation of the returns
+func RealizedVolatility(returns []float64, periodsPerYear float64) float64 {
+	_, std := MeanStd(returns)
+	return std * math.Sqrt(periodsPerYear)
+}
+
+// ParkinsonVolatility is the annualized volatility estimated from each bar's high and low
+func ParkinsonVolatility(bars []data.MyBar, periodsPerYear float64) float64 {
+	sum := 0.0
+	n := 0
+	for _, b := range bars {
+		if b.High <= 0 || b.Low <= 0 {
+			continue
+		}
+		hl := math.Log(float64(b.High / b.Low))
+		sum += hl * hl
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Sqrt(sum / (4 * math.Ln2 * float64(n)) * periodsPerYear)
+}
+
+// GarmanKlassVolatility is the annualized volatility estimated from each bar's open, high, low and close
+func GarmanKlassVolatility(bars []data.MyBar, periodsPerYear float64) float64 {
+	sum := 0.0
+	n := 0
+	for _, b := range bars {
+		if b.High <= 0 || b.Low <= 0 || b.Open <= 0 || b.Close <= 0 {
+			continue
+		}
+		hl := math.Log(float64(b.High / b.Low))
+		co := math.Log(float64(b.Close / b.Open))
+		sum += 0.5*hl*hl - (2*math.Ln2-1)*co*co
+		n++
+	}
+	if n == 0 || sum <= 0 {
+		return 0
+	}
+	return math.Sqrt(sum / float64(n) * periodsPerYear)
+}
+
+// Autocorrelation of the returns with themselves lag bars earlier
+func Autocorrelation(returns []float64, lag int) float64 {
+	if len(returns) <= lag {
+		return 0
+	}
+	mean, _ := MeanStd(returns)
+	numerator := 0.0
+	denominator := 0.0
+	for i, r := range returns {
+		denominator += (r - mean) * (r - mean)
+		if i >= lag {
+			numerator += (r - mean) * (returns[i-lag] - mean)
+		}
+	}
+	if denominator == 0 {
+		return 0
+	}
+	return numerator / denominator
+}
+
+// Skew of the returns, negative when large losses are more common than large gains
+func Skew(returns []float64) float64 {
+	mean, std := MeanStd(returns)
+	if std == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, r := range returns {
+		sum += math.Pow((r-mean)/std, 3)
+	}
+	return sum / float64(len(returns))
+}
+
+// Kurtosis of the returns in excess of a normal distribution, positive when tails are fat
+func Kurtosis(returns []float64) float64 {
+	mean, std := MeanStd(returns)
+	if std == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, r := range returns {
+		sum += math.Pow((r-mean)/std, 4)
+	}
+	return sum/float64(len(returns)) - 3
+}
+
+// MeanStd returns the mean and sample standard deviation of the values
+func MeanStd(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean = mean / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)-1))
+}
+
+// LinearRegression fits y = intercept + slope * x by ordinary least squares, the slope is 0 when x does not vary
+func LinearRegression(x []float64, y []float64) (slope float64, intercept float64) {
+	meanX, _ := MeanStd(x)
+	meanY, _ := MeanStd(y)
+	covariance := 0.0
+	variance := 0.0
+	for i := range x {
+		covariance += (x[i] - meanX) * (y[i] - meanY)
+		variance += (x[i] - meanX) * (x[i] - meanX)
+	}
+	if variance == 0 {
+		return 0, meanY
+	}
+	slope = covariance / variance
+	return slope, meanY - slope*meanX
+}
+
+// Correlation is the pearson correlation of two series of the same length, 0 if either does not vary
+func Correlation(a []float64, b []float64) float64 {
+	meanA, stdA := MeanStd(a)
+	meanB, stdB := MeanStd(b)
+	if stdA == 0 || stdB == 0 || len(a) < 2 {
+		return 0
+	}
+	sum := 0.0
+	for i := range a {
+		sum += (a[i] - meanA) * (b[i] - meanB)
+	}
+	return sum / float64(len(a)-1) / (stdA * stdB)
+}
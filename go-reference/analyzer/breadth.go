@@ -0,0 +1,138 @@
+package analyzer
+
+import (
+	"fmt"
+
+	"github.com/mcmohorn/market/server/data"
+)
+
+const (
+	highLowLookback = 252  // bars to look back when deciding on a new high / low (a year of days)
+	mcClellanFast   = 19.0 // length of the fast ema of net advances
+	mcClellanSlow   = 39.0 // length of the slow ema of net advances
+)
+
+// CalculateBreadth computes market breadth for every distinct bar time found in the given symbols (see Timeline)
+func CalculateBreadth(symbols []data.SymbolData) []data.Breadth {
+	times := Timeline(symbols)
+	acc := newBreadthAccumulator(len(times))
+	cursor := NewTimelineCursor(symbols)
+	for _, t := range times {
+		b := acc.start(t)
+		for k, i := range cursor.Advance(t) {
+			if i >= 0 {
+				addBar(b, symbols[k].Bars, i)
+			}
+		}
+		acc.finish()
+	}
+
+	return acc.result
+}
+
+// LatestBreadth returns the breadth of the most recent bar time, or an empty Breadth when there is no data
+func LatestBreadth(symbols []data.SymbolData) data.Breadth {
+	breadth := CalculateBreadth(symbols)
+	if len(breadth) == 0 {
+		return data.Breadth{}
+	}
+	return breadth[len(breadth)-1]
+}
+
+// IsBreadthWeak tells whether fewer than minPercent of the symbols are on a buy signal (a minPercent of 0 never is)
+func IsBreadthWeak(b data.Breadth, minPercent float32) bool {
+	return minPercent > 0 && b.Total > 0 && b.PercentBuy < minPercent
+}
+
+// PrettyBreadth formats breadth for the status area
+func PrettyBreadth(b data.Breadth) string {
+	return fmt.Sprintf("Buy %.0f%% (%v/%v) | A/D %v/%v line %v | Highs/Lows %v/%v | McClellan %.1f",
+		b.PercentBuy, b.BuySignals, b.Total, b.Advances, b.Declines, b.AdvanceDecline, b.NewHighs, b.NewLows, b.McClellan)
+}
+
+// priceRange finds the highest and lowest price in the lookback window before bar i
+func priceRange(bars []data.MyBar, i int) (float32, float32) {
+	start := i - highLowLookback
+	if start < 0 {
+		start = 0
+	}
+	high := bars[start].Price
+	low := bars[start].Price
+	for _, b := range bars[start:i] {
+		if b.Price > high {
+			high = b.Price
+		}
+		if b.Price < low {
+			low = b.Price
+		}
+	}
+	return high, low
+}
+
+// addBar counts bar i of a symbol towards the breadth of its time
+func addBar(b *data.Breadth, bars []data.MyBar, i int) {
+	bar := bars[i]
+	b.Total++
+	if bar.BuySignal {
+		b.BuySignals++
+	}
+	if i == 0 {
+		return
+	}
+
+	prev := bars[i-1].Price
+	if bar.Price > prev {
+		b.Advances++
+	} else if bar.Price < prev {
+		b.Declines++
+	} else {
+		b.Unchanged++
+	}
+
+	high, low := priceRange(bars, i)
+	if bar.Price > high {
+		b.NewHighs++
+	} else if bar.Price < low {
+		b.NewLows++
+	}
+}
+
+// breadthAccumulator carries the running advance/decline line and mcclellan emas from one bar time to the next
+type breadthAccumulator struct {
+	result  []data.Breadth
+	emaFast float32
+	emaSlow float32
+}
+
+func newBreadthAccumulator(n int) *breadthAccumulator {
+	return &breadthAccumulator{result: make([]data.Breadth, 0, n)}
+}
+
+// start begins a new bar time and returns the breadth to fill in
+func (acc *breadthAccumulator) start(t int64) *data.Breadth {
+	acc.result = append(acc.result, data.Breadth{Time: t})
+	return &acc.result[len(acc.result)-1]
+}
+
+// finish computes the values that depend on the completed counts and on previous bar times
+func (acc *breadthAccumulator) finish() {
+	n := len(acc.result) - 1
+	b := &acc.result[n]
+	if b.Total > 0 {
+		b.PercentBuy = 100.0 * float32(b.BuySignals) / float32(b.Total)
+	}
+
+	a1 := float32(2.0 / (mcClellanFast + 1.0))
+	a2 := float32(2.0 / (mcClellanSlow + 1.0))
+	net := float32(b.Advances - b.Declines)
+	if n == 0 {
+		b.AdvanceDecline = b.Advances - b.Declines
+		acc.emaFast = net
+		acc.emaSlow = net
+	} else {
+		b.AdvanceDecline = acc.result[n-1].AdvanceDecline + b.Advances - b.Declines
+		acc.emaFast = a1*net + (1-a1)*acc.emaFast
+		acc.emaSlow = a2*net + (1-a2)*acc.emaSlow
+	}
+	b.McClellan = acc.emaFast - acc.emaSlow
+}
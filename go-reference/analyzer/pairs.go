@@ -0,0 +1,129 @@
+package analyzer
+
+import (
+	"math"
+	"sort"
+
+	"github.com/mcmohorn/market/server/analytics"
+	"github.com/mcmohorn/market/server/data"
+)
+
+const (
+	// DefaultCriticalValue is the 5% critical value of the engle-granger test for two variables (MacKinnon)
+	DefaultCriticalValue = -3.34
+	defaultMaxHalfLife   = 30.0 // bars, slower spreads tie up capital for too long
+)
+
+// EngleGranger regresses y on x and runs an augmented dickey-fuller test (no lags) on the residuals,
+// returning the hedge ratio, intercept, the test statistic and the half life of the spread in bars
+func EngleGranger(y []float64, x []float64) (beta float64, alpha float64, adf float64, halfLife float64) {
+	beta, alpha = analytics.LinearRegression(x, y)
+
+	spread := make([]float64, len(y))
+	for i := range y {
+		spread[i] = y[i] - beta*x[i] - alpha
+	}
+
+	// regress the change in spread on the previous spread: delta e(t) = gamma * e(t-1)
+	sumLag := 0.0
+	sumLagDelta := 0.0
+	for t := 1; t < len(spread); t++ {
+		sumLag += spread[t-1] * spread[t-1]
+		sumLagDelta += spread[t-1] * (spread[t] - spread[t-1])
+	}
+	if sumLag == 0 || len(spread) < 3 {
+		return beta, alpha, 0, math.Inf(1)
+	}
+	gamma := sumLagDelta / sumLag
+
+	sumSquares := 0.0
+	for t := 1; t < len(spread); t++ {
+		u := spread[t] - spread[t-1] - gamma*spread[t-1]
+		sumSquares += u * u
+	}
+	standardError := math.Sqrt(sumSquares / float64(len(spread)-2) / sumLag)
+	if standardError > 0 {
+		adf = gamma / standardError
+	}
+
+	halfLife = math.Inf(1)
+	if gamma < 0 {
+		halfLife = -math.Ln2 / math.Log1p(gamma)
+	}
+	return
+}
+
+// SpreadZScores computes the z-score of the spread y - beta*x - alpha against its trailing lookback window,
+// the first lookback-1 z-scores are 0
+func SpreadZScores(y []float64, x []float64, beta float64, alpha float64, lookback int) []float64 {
+	z := make([]float64, len(y))
+	spread := make([]float64, len(y))
+	for i := range y {
+		spread[i] = y[i] - beta*x[i] - alpha
+		if i < lookback-1 {
+			continue
+		}
+		mean, std := analytics.MeanStd(spread[i-lookback+1 : i+1])
+		if std > 0 {
+			z[i] = (spread[i] - mean) / std
+		}
+	}
+	return z
+}
+
+// ScanPairs tests every pair of the given (aligned) symbols for cointegration of their log prices
+// over bars [start, end) and returns the tradable ones, most strongly cointegrated first
+func ScanPairs(symbols []data.SymbolData, start int, end int, opts *data.PairsOptions) []data.Pair {
+	criticalValue := opts.CriticalValue
+	if criticalValue == 0 {
+		criticalValue = DefaultCriticalValue
+	}
+	maxHalfLife := opts.MaxHalfLife
+	if maxHalfLife == 0 {
+		maxHalfLife = defaultMaxHalfLife
+	}
+
+	logPrices := make([][]float64, len(symbols))
+	for k, s := range symbols {
+		logPrices[k] = LogPrices(s.Bars[start:end])
+	}
+
+	pairs := make([]data.Pair, 0)
+	for i := 0; i < len(symbols); i++ {
+		for j := i + 1; j < len(symbols); j++ {
+			if logPrices[i] == nil || logPrices[j] == nil {
+				continue
+			}
+			beta, alpha, adf, halfLife := EngleGranger(logPrices[i], logPrices[j])
+			if adf > criticalValue || beta <= 0 || halfLife > maxHalfLife {
+				continue
+			}
+			pairs = append(pairs, data.Pair{
+				First:       symbols[i].Symbol,
+				Second:      symbols[j].Symbol,
+				HedgeRatio:  beta,
+				Intercept:   alpha,
+				ADF:         adf,
+				HalfLife:    halfLife,
+				Correlation: analytics.Correlation(logPrices[i], logPrices[j]),
+			})
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].ADF < pairs[j].ADF
+	})
+	return pairs
+}
+
+// LogPrices returns the natural log of each bar's price, or nil if any price is not positive
+func LogPrices(bars []data.MyBar) []float64 {
+	result := make([]float64, len(bars))
+	for i, b := range bars {
+		if b.Price <= 0 {
+			return nil
+		}
+		result[i] = math.Log(float64(b.Price))
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+package analyzer
+
+import (
+	"github.com/mcmohorn/market/server/analytics"
+	"github.com/mcmohorn/market/server/data"
+)
+
+const (
+	defaultTrendLength      = 50
+	defaultVolatilityLength = 20
+	trendSlopeBars          = 5    // bars over which the moving average must be rising / falling
+	highVolatilityRatio     = 1.5  // volatility this many times its running average counts as high
+	strongBreadthPercent    = 60.0 // percent of symbols on buy signals that confirms a bull market
+	weakBreadthPercent      = 40.0 // percent of symbols on buy signals that confirms a bear market
+)
+
+// ClassifyRegimes labels each bar time of the given breadth (see CalculateBreadth) as bull, bear or
+// sideways using the benchmark trend, the benchmark volatility and the breadth itself. Every label
+// only depends on bars up to and including its own time so it is safe to trade on.
+func ClassifyRegimes(symbols []data.SymbolData, breadth []data.Breadth, opts data.RegimeOptions) []data.Regime {
+	trendLength := opts.TrendLength
+	if trendLength <= 0 {
+		trendLength = defaultTrendLength
+	}
+	volatilityLength := opts.VolatilityLength
+	if volatilityLength <= 0 {
+		volatilityLength = defaultVolatilityLength
+	}
+
+	times := make([]int64, len(breadth))
+	for n, b := range breadth {
+		times[n] = b.Time
+	}
+	prices := BenchmarkPrices(symbols, opts.Benchmark, times)
+	regimes := make([]data.Regime, len(prices))
+
+	sma := make([]float64, len(prices))
+	sum := 0.0
+	volatilitySum := 0.0
+	volatilityCount := 0
+	for i := range prices {
+		sum += prices[i]
+		if i >= trendLength {
+			sum -= prices[i-trendLength]
+		}
+		if i < trendLength-1 {
+			regimes[i] = data.Sideways
+			continue
+		}
+		sma[i] = sum / float64(trendLength)
+
+		// trend of the benchmark against its moving average
+		trend := 0
+		if i >= trendLength-1+trendSlopeBars {
+			if prices[i] > sma[i] && sma[i] > sma[i-trendSlopeBars] {
+				trend = 1
+			} else if prices[i] < sma[i] && sma[i] < sma[i-trendSlopeBars] {
+				trend = -1
+			}
+		}
+
+		// participation of the rest of the market
+		participation := 0
+		if breadth[i].Total > 0 {
+			if breadth[i].PercentBuy > strongBreadthPercent {
+				participation = 1
+			} else if breadth[i].PercentBuy < weakBreadthPercent {
+				participation = -1
+			}
+		}
+
+		// volatility well above what we have seen so far undermines any trend
+		highVolatility := false
+		if i >= volatilityLength {
+			v := analytics.RealizedVolatility(analytics.PriceLogReturns(prices[i-volatilityLength:i+1]), 1)
+			if volatilityCount > 0 && v > highVolatilityRatio*volatilitySum/float64(volatilityCount) {
+				highVolatility = true
+			}
+			volatilitySum += v
+			volatilityCount++
+		}
+
+		score := 2*trend + participation
+		if highVolatility {
+			score--
+		}
+		if score >= 2 {
+			regimes[i] = data.Bull
+		} else if score <= -2 {
+			regimes[i] = data.Bear
+		} else {
+			regimes[i] = data.Sideways
+		}
+	}
+
+	return regimes
+}
+
+// BenchmarkPrices returns the price of the benchmark symbol at each of the given times (carrying its last
+// price over times it has no bar), falling back to an equal weight index of every symbol starting at 1
+// if the benchmark is not in the data
+func BenchmarkPrices(symbols []data.SymbolData, benchmark string, times []int64) []float64 {
+	prices := make([]float64, len(times))
+
+	for k, s := range symbols {
+		if s.Symbol != benchmark {
+			continue
+		}
+		cursor := NewTimelineCursor(symbols[k : k+1])
+		last := 0.0
+		for n, t := range times {
+			if i := cursor.Advance(t)[0]; i >= 0 {
+				last = float64(s.Bars[i].Price)
+			}
+			prices[n] = last
+		}
+		return prices
+	}
+
+	cursor := NewTimelineCursor(symbols)
+	for n, t := range times {
+		indexes := cursor.Advance(t)
+		if n == 0 {
+			prices[n] = 1
+			continue
+		}
+		change := 0.0
+		count := 0
+		for k, i := range indexes {
+			if i > 0 && symbols[k].Bars[i-1].Price > 0 {
+				change += float64(symbols[k].Bars[i].Price / symbols[k].Bars[i-1].Price)
+				count++
+			}
+		}
+		if count == 0 {
+			prices[n] = prices[n-1]
+		} else {
+			prices[n] = prices[n-1] * change / float64(count)
+		}
+	}
+	return prices
+}
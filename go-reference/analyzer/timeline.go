@@ -0,0 +1,67 @@
+package analyzer
+
+import (
+	"sort"
+
+	"github.com/mcmohorn/market/server/data"
+)
+
+// Timeline returns every distinct bar time found in the given symbols, in order
+func Timeline(symbols []data.SymbolData) []int64 {
+	times := make([]int64, 0)
+	seen := make(map[int64]bool)
+	for _, s := range symbols {
+		for _, b := range s.Bars {
+			if !seen[b.Time] {
+				seen[b.Time] = true
+				times = append(times, b.Time)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i] < times[j]
+	})
+	return times
+}
+
+// TimelineCursor walks forward through time across symbols whose bars are not necessarily aligned
+type TimelineCursor struct {
+	symbols   []data.SymbolData
+	positions []int
+	indexes   []int
+}
+
+// NewTimelineCursor creates a cursor positioned before the first bar of every symbol
+func NewTimelineCursor(symbols []data.SymbolData) *TimelineCursor {
+	return &TimelineCursor{
+		symbols:   symbols,
+		positions: make([]int, len(symbols)),
+		indexes:   make([]int, len(symbols)),
+	}
+}
+
+// Advance moves the cursor to time t (which must not be before the last one) and returns the index
+// of each symbol's bar at exactly that time, or -1 for symbols without a bar then. The returned
+// slice is reused by the next call.
+func (c *TimelineCursor) Advance(t int64) []int {
+	for k, s := range c.symbols {
+		// bars are in time order so we only ever move forward
+		for c.positions[k] < len(s.Bars) && s.Bars[c.positions[k]].Time < t {
+			c.positions[k]++
+		}
+		if c.positions[k] < len(s.Bars) && s.Bars[c.positions[k]].Time == t {
+			c.indexes[k] = c.positions[k]
+		} else {
+			c.indexes[k] = -1
+		}
+	}
+	return c.indexes
+}
+
+// Seen returns how many bars of symbol k are at or before the cursor's time
+func (c *TimelineCursor) Seen(k int) int {
+	if c.indexes[k] >= 0 {
+		return c.indexes[k] + 1
+	}
+	return c.positions[k]
+}
@@ -2,11 +2,11 @@ package app
 
 import (
         "fmt"
+        "io"
         "log"
         "math"
         "os"
         "reflect"
-        "sort"
         "sync"
         "time"
 
@@ -20,13 +20,17 @@ import (
         "github.com/alpacahq/alpaca-trade-api-go/common"
         "github.com/fabioberger/coinbase-go"
         "github.com/gorilla/mux"
+        "github.com/mcmohorn/market/server/analytics"
+        "github.com/mcmohorn/market/server/analyzer"
         "github.com/mcmohorn/market/server/config"
         "github.com/mcmohorn/market/server/data"
         "github.com/mcmohorn/market/server/db"
+        "github.com/mcmohorn/market/server/decimal"
         "github.com/mcmohorn/market/server/helper"
         "github.com/mcmohorn/market/server/indicators"
         "github.com/mcmohorn/market/server/reader"
         "github.com/mcmohorn/market/server/services"
+        "github.com/mcmohorn/market/server/strategy"
 
         "go.mongodb.org/mongo-driver/mongo"
 )
@@ -52,6 +56,8 @@ type App struct {
         currentCryptoData  []data.SymbolData
         currentPositions   []data.MyPosition
         account            robinhood.Account
+        breadth            data.Breadth
+        dayTrader          strategy.Strategy
 
         header string
         footer string
@@ -63,6 +69,9 @@ type App struct {
         sortDiffAdjustedAscending bool
         sortNAscending            bool
         sortNChangesAscending     bool
+
+        showingDetail bool
+        showStats     bool
 }
 
 func getField(v *data.MyBar, field string) float32 {
@@ -71,14 +80,20 @@ func getField(v *data.MyBar, field string) float32 {
         return float32(f.Float())
 }
 
-func (a *App) StartDayTrader() {
-        options := data.DayTraderOptions{
+// dayTraderOptions are the settings the day trader trades with
+func dayTraderOptions() data.DayTraderOptions {
+        return data.DayTraderOptions{
                 Interval:      60,
                 MinCashLimit:  5,
                 MaxSharePrice: 2000,
                 MinBuySignal:  0.001,
+                MinBreadth:    0,
                 PerformTrades: true,
         }
+}
+
+func (a *App) StartDayTrader() {
+        options := dayTraderOptions()
         a.header = "Minutely"
         a.Timeframe = "minute"
         a.OperateDayTrader(&options)
@@ -111,6 +126,7 @@ func (a *App) StartCryptoAnalysis() {
         cryptodata, _ := a.GrabDataAndAnalyze(&wg, &cryptoOpts)
         wg.Wait()
         a.currentData = cryptodata
+        a.UpdateBreadth()
         go a.DrawTable()
 }
 
@@ -154,6 +170,7 @@ func (a *App) StartEndOfDayAnalysis() {
         stockData, _ := a.GrabDataAndAnalyze(&wg, &options)
         wg.Wait()
         a.currentData = stockData
+        a.UpdateBreadth()
 
         a.UpdateCurrentPositionsFromCurrentData()
         a.SetAppStatus("Stock Analysis Complete")
@@ -174,9 +191,18 @@ func (a *App) UpdateCurrentPositionsFromCurrentData() {
 
 func (a *App) SetAppStatus(status string) {
         a.status = status
+        if a.breadth.Total > 0 {
+                status = status + "\n" + analyzer.PrettyBreadth(a.breadth)
+        }
         a.statusText.SetText(status)
 }
 
+// UpdateBreadth recomputes market breadth over the current data and shows it under the status
+func (a *App) UpdateBreadth() {
+        a.breadth = analyzer.LatestBreadth(a.currentData)
+        a.SetAppStatus(a.status)
+}
+
 // AnalyzeTickersInFile reads a file where each line is a stock ticker and analyzes the bars to return better mybars
 func (a *App) GrabDataAndAnalyze(wg *sync.WaitGroup, opts *data.AnalysisOptions) ([]data.SymbolData, error) {
         defer wg.Done()
@@ -219,13 +245,8 @@ func (a *App) GrabDataAndAnalyze(wg *sync.WaitGroup, opts *data.AnalysisOptions)
                 v := <-results
 
                 for key, bars := range v {
-                        if len(bars) > 100 { // TODO : are we throwing out too many here, probably doesn't matter
-                                tempData = append(tempData, data.SymbolData{
-                                        Symbol:           key,
-                                        Bars:             bars,
-                                        CurrentPrice:     bars[len(bars)-1].Price,
-                                        CurrentBuySignal: bars[len(bars)-1].BuySignal,
-                                })
+                        if symbol, ok := newSymbolData(key, bars, opts); ok {
+                                tempData = append(tempData, symbol)
                         }
 
                 }
@@ -235,6 +256,21 @@ func (a *App) GrabDataAndAnalyze(wg *sync.WaitGroup, opts *data.AnalysisOptions)
 
 }
 
+// newSymbolData describes the analyzed bars of a symbol, false if there are too few of them to trade on
+func newSymbolData(symbol string, bars []data.MyBar, opts *data.AnalysisOptions) (data.SymbolData, bool) {
+        if len(bars) <= 100 { // TODO : are we throwing out too many here, probably doesn't matter
+                return data.SymbolData{}, false
+        }
+        return data.SymbolData{
+                Symbol:           symbol,
+                Bars:             bars,
+                CurrentPrice:     bars[len(bars)-1].Price,
+                CurrentBuySignal: bars[len(bars)-1].BuySignal,
+                Levels:           indicators.CalculateLevels(bars),
+                Stats:            analytics.CalculateStats(bars, analytics.PeriodsPerYear(opts.Timeframe == "minute", opts.IsCrypto)),
+        }, true
+}
+
 func (a *App) worker(id int, jobs <-chan []string, results chan<- map[string][]data.MyBar, errors chan<- error, opts *data.AnalysisOptions) {
         for job := range jobs {
                 //fmt.Println("worker", id, "started  job", job)
@@ -258,12 +294,158 @@ func (a *App) SimulateTrader() {
                 MaxSharePrice:     float32(4000.0),
                 Iterations:        3,
                 ShowWorkLists:     true,
+                Regime:            data.RegimeOptions{Benchmark: "SPY"},
+                Benchmark:         "SPY",
+        }
+        if _, err := RunSimulation(a.currentData, &opts); err != nil {
+                fmt.Println(err)
+        }
+
+}
+
+// SweepTrader searches for the simulation settings that give the best sharpe ratio
+func (a *App) SweepTrader() {
+        opts := data.SweepOptions{
+                Simulation: data.SimulationOptions{
+                        NumberOfIntervals: 330,
+                        IntervalFormat:    data.Minute,
+                        StartingCash:      float32(2000.0),
+                        MinBuySignal:      float32(0.1),
+                        MinCashLimit:      float32(100.0),
+                        MaxSharePrice:     float32(4000.0),
+                        Iterations:        20,
+                        Regime:            data.RegimeOptions{Benchmark: "SPY"},
+                        Benchmark:         "SPY",
+                },
+                MinBuySignal: data.ParameterRange{Start: 0.05, End: 0.5, Step: 0.05},
+                FastPeriod:   data.ParameterRange{Start: 8, End: 16, Step: 2},
+                Metric:       "sharpe",
+                Top:          20,
+                HeatmapFile:  "sweep.png",
+                HeatmapX:     "minbuysignal",
+                HeatmapY:     "fast",
+        }
+        results, err := RunSweep(a.currentData, &opts)
+        if err != nil {
+                fmt.Println(err)
+                return
+        }
+        PrintSweep(results, opts.Metric, opts.Top)
+        if opts.HeatmapFile != "" {
+                if err := PlotSweepHeatmap(results, opts.Metric, opts.HeatmapX, opts.HeatmapY, opts.HeatmapFile); err != nil {
+                        fmt.Println(err)
+                }
+        }
+}
+
+// WalkForwardTrader tunes the simulation settings on a rolling window and trades them on the window after it
+func (a *App) WalkForwardTrader() {
+        opts := data.WalkForwardOptions{
+                Sweep: data.SweepOptions{
+                        Simulation: data.SimulationOptions{
+                                IntervalFormat: data.Minute,
+                                StartingCash:   float32(2000.0),
+                                MinBuySignal:   float32(0.1),
+                                MinCashLimit:   float32(100.0),
+                                MaxSharePrice:  float32(4000.0),
+                                Regime:         data.RegimeOptions{Benchmark: "SPY"},
+                                Benchmark:      "SPY",
+                        },
+                        MinBuySignal: data.ParameterRange{Start: 0.05, End: 0.5, Step: 0.05},
+                        FastPeriod:   data.ParameterRange{Start: 8, End: 16, Step: 2},
+                        Metric:       "sharpe",
+                },
+                InSample:    1000,
+                OutOfSample: 330,
+        }
+        wf, err := RunWalkForward(a.currentData, &opts)
+        if err != nil {
+                fmt.Println(err)
+                return
+        }
+        PrintWalkForward(wf, opts.Sweep.Metric)
+}
+
+// MonteCarloTrader tests how much of the simulated result was luck
+func (a *App) MonteCarloTrader() {
+        opts := data.MonteCarloOptions{
+                Simulation: data.SimulationOptions{
+                        NumberOfIntervals: 330,
+                        IntervalFormat:    data.Minute,
+                        StartingCash:      float32(2000.0),
+                        MinBuySignal:      float32(0.1),
+                        MinCashLimit:      float32(100.0),
+                        MaxSharePrice:     float32(4000.0),
+                        Regime:            data.RegimeOptions{Benchmark: "SPY"},
+                        Benchmark:         "SPY",
+                },
+                Paths:         1000,
+                EntryDelay:    2,
+                PriceNoise:    0.001,
+                HistogramFile: "montecarlo",
         }
-        RunSimulation(CleanDates(a.currentData), &opts)
+        mc, err := RunMonteCarlo(a.currentData, &opts)
+        if err != nil {
+                fmt.Println(err)
+                return
+        }
+        PrintMonteCarlo(mc)
+}
 
+// ReplayDayTrader shows what the day trader would have done yesterday, replaying its minute bars
+func (a *App) ReplayDayTrader() {
+        day := time.Now().AddDate(0, 0, -1)
+        opts := data.ReplayOptions{
+                DayTrader:    dayTraderOptions(),
+                Day:          day,
+                StartingCash: float32(2000.0),
+                ShowWorkList: true,
+                Quiet:        true,
+        }
+        // the routine looks back a day, so the day before is needed too
+        symbols := a.minuteData(day.AddDate(0, 0, -2), day.AddDate(0, 0, 1))
+        result, err := RunReplay(symbols, &opts)
+        if err != nil {
+                fmt.Println(err)
+                return
+        }
+        PrintReplay(result, opts.ShowWorkList)
+}
+
+// SimulatePairs scans the current data for cointegrated pairs and backtests trading their spreads
+func (a *App) SimulatePairs() {
+        opts := data.PairsOptions{
+                FormationIntervals: 120,
+                MaxPairs:           5,
+                ZLookback:          20,
+                EntryZ:             2.0,
+                ExitZ:              0.5,
+                StopZ:              3.5,
+                Simulation: data.SimulationOptions{
+                        NumberOfIntervals: 60,
+                        Iterations:        3,
+                        StartingCash:      float32(2000.0),
+                        ShowWorkLists:     true,
+                        Shorts:            data.ShortOptions{Enabled: true},
+                },
+        }
+        cleaned := CleanDates(a.currentData)
+
+        pairs, err := LatestPairs(cleaned, &opts)
+        if err != nil {
+                fmt.Println(err)
+                return
+        }
+        PrintPairs(pairs)
+        if _, err := RunPairsSimulation(cleaned, &opts); err != nil {
+                fmt.Println(err)
+        }
 }
 
 func (a *App) OperateDayTrader(opts *data.DayTraderOptions) {
+        if err := wholeLots(opts); err != nil {
+                log.Panic(err)
+        }
 
         // anything holding at the beginning of the day is off the table (assumed in rh.txt)
         var wg sync.WaitGroup
@@ -285,6 +467,7 @@ func (a *App) OperateDayTrader(opts *data.DayTraderOptions) {
 
         }
         a.forbiddenSymbols = holdSymbols
+        a.dayTrader = strategy.NewDayTraderMACD(opts)
 
         // repeatedly invoke the trading routine every x seconds
         ticker := time.NewTicker(time.Duration(opts.Interval) * time.Second)
@@ -304,99 +487,73 @@ func (a *App) OperateDayTrader(opts *data.DayTraderOptions) {
 
 }
 
+// DoTradingRoutine decides what to trade on the latest minute bars and trades it on robinhood
 func (a *App) DoTradingRoutine(opts *data.DayTraderOptions) {
+        a.tradingRoutine(opts, time.Now(), a.minuteData, &robinhoodBroker{app: a}, os.Stdout)
+}
 
-        fmt.Println("Doing trading routine")
+// minuteData pulls the minute bars of the symbols we trade from start to end from alpaca and analyzes them
+func (a *App) minuteData(start time.Time, end time.Time) []data.SymbolData {
         var wg sync.WaitGroup
-        // Step 1: pull data from alpaca / compute emas
         analysisOptions := data.AnalysisOptions{
                 Filename:          "tickers2.txt",
                 Timeframe:         "minute",
                 Concurrency:       2,
                 SymbolsPerRequest: 100,
-                StartTime:         time.Now().AddDate(0, 0, -1),
-                EndTime:           time.Now(),
+                StartTime:         start,
+                EndTime:           end,
                 IsCrypto:          false,
         }
         wg.Add(1)
         data, _ := a.GrabDataAndAnalyze(&wg, &analysisOptions)
         wg.Wait()
-        a.currentData = data
+        return data
+}
 
-        // Step 2: pull holdings for designated portfolio / account from robinhood
-        //ctx := context.Background()
-        wg.Add(1)
-        positions, _ := services.GetPositions(a.robinhoodClient, &wg, a.account)
-        wg.Wait()
+// tradingRoutine is a single decision of the day trader at now, on the bars from feed and trading through
+// broker. Live trading and replays of past days share it so they behave the same.
+func (a *App) tradingRoutine(opts *data.DayTraderOptions, now time.Time, feed MarketFeed, broker Broker, out io.Writer) {
 
+        fmt.Fprintln(out, "Doing trading routine")
+        // Step 1: pull data from alpaca / compute emas
+        a.currentData = feed(now.AddDate(0, 0, -1), now)
+        a.breadth = analyzer.LatestBreadth(a.currentData)
+
+        // Step 2: pull holdings and cash for designated portfolio / account
+        positions, _ := broker.Positions()
+        cash, _ := broker.BuyingPower()
+
+        // Step 3: let the strategy decide on the latest bars with what we are allowed to trade
+        portfolio := &strategy.Portfolio{
+                Cash:   cash,
+                Shares: make(map[string]decimal.Decimal),
+        }
         for _, p := range positions {
-                // decide whether to sell each position
                 if helper.IsInList(p.Symbol, a.forbiddenSymbols) {
                         // skip this position, its in our forbidden list
                         continue
                 }
-
-                // find matching SymbolData in the current data
-                for _, x := range a.currentData {
-                        if x.Symbol == p.Symbol {
-                                if x.CurrentBuySignal && opts.PerformTrades && p.Quantity > 0 {
-                                        // sell this holding
-                                        wg.Add(1)
-                                        services.TradeQuantityAtPrice(a.robinhoodClient, &wg, a.DB, p.Symbol, p.Quantity, float64(p.CurrentPrice), robinhood.Sell)
-                                }
-                                break
-                        }
-                }
+                portfolio.Shares[p.Symbol] = p.Quantity
         }
-        wg.Wait() // wait to finish selling
-
-        // use remaining buying power in portfolio to purchase as much of the best
-        wg.Add(1)
-        account, _ := services.GetMyAccount(a.robinhoodClient, &wg)
-        a.account = account
-        wg.Wait() // wait for account to be retrieved
-
-        // sort data by best buy signals
-        sort.SliceStable(a.currentData, func(k, j int) bool {
-                return a.currentData[k].Bars[len(a.currentData[k].Bars)-1].DiffAdjusted < a.currentData[j].Bars[len(a.currentData[j].Bars)-1].DiffAdjusted
-        })
-
-        max := float32(0.0)
-        balanceAvailable := float32(account.CashAvailableForWithdrawal)
-
-        bestIndex := -1
-        for j := 0; j < len(a.currentData); j++ {
-                if a.currentData[j].Bars[len(a.currentData[j].Bars)-1].Diff > opts.MinBuySignal &&
-                        a.currentData[j].Bars[len(a.currentData[j].Bars)-1].Price < opts.MaxSharePrice &&
-                        a.currentData[j].Bars[len(a.currentData[j].Bars)-1].Price < balanceAvailable &&
-                        a.currentData[j].Bars[len(a.currentData[j].Bars)-1].BuySignal &&
-                        a.currentData[j].Bars[len(a.currentData[j].Bars)-1].Price < float32(account.BuyingPower) {
-                        bestIndex = j
-                }
-                if a.currentData[j].Bars[len(a.currentData[j].Bars)-1].Diff > max {
-                        max = a.currentData[j].Bars[len(a.currentData[j].Bars)-1].Diff
-                }
+        market := &strategy.MarketState{
+                Data:    a.currentData,
+                Index:   -1,
+                Breadth: a.breadth,
         }
+        orders := a.dayTrader.OnBar(market, portfolio)
 
-        fmt.Printf("max signal was %v\n", max, bestIndex)
-
-        fmt.Printf("available : %v\n", balanceAvailable)
-        fmt.Printf("account : %+v\n", a.account)
-
-        if float32(balanceAvailable) > opts.MinCashLimit && bestIndex > -1 {
-                // buy as much as we can of the good stuff if we have cash
-                // TODO maybe canbuy should be on instrument current price instead
-                canBuy := int(math.Floor(float64(float32(balanceAvailable) / a.currentData[bestIndex].Bars[len(a.currentData[bestIndex].Bars)-1].Price)))
-
-                // tODO maybe ignore limit price and do it at whatever instrument currently is
-                limitPrice := a.currentData[bestIndex].Bars[len(a.currentData[bestIndex].Bars)-1].Price
+        fmt.Fprintf(out, "available : %v\n", portfolio.Cash)
 
-                // fmt.Printf("trying to buy %v of  %v at %v\n", canBuy, a.currentData[bestIndex].Symbol, limitPrice)
-
-                if canBuy > 0 && opts.PerformTrades {
-                        wg.Add(1)
-                        services.TradeQuantityAtPrice(a.robinhoodClient, &wg, a.DB, a.currentData[bestIndex].Symbol, float32(canBuy), float64(limitPrice), robinhood.Buy)
-                        wg.Wait()
+        // Step 4: submit the orders
+        if !opts.PerformTrades {
+                return
+        }
+        for _, order := range orders {
+                if !order.Buy {
+                        fmt.Fprintf(out, "selling %v shares of %v at $%v on %v\n", order.Quantity, order.Symbol, order.Price.StringFixed(2), order.Reason)
+                }
+                if err := broker.Submit(order); err != nil {
+                        fmt.Fprintf(out, "could not trade %v: %v\n", order.Symbol, err)
                 }
         }
 
@@ -467,11 +624,7 @@ func (a *App) AnalyzeSymbols(symbols []string, options *data.AnalysisOptions) (m
 
         // do analysis for each of the companies
         for key, bars := range results {
-                newbars := bars
-                newbars = indicators.CalculateMACD(newbars)
-                newbars = indicators.CalculateRSI(newbars)
-
-                finalResults[key] = newbars
+                finalResults[key] = analyzeBars(bars)
         }
 
         return finalResults, nil
@@ -481,7 +634,7 @@ func (a *App) AnalyzeSymbols(symbols []string, options *data.AnalysisOptions) (m
 func (a *App) Initialize(c *config.Config, wg *sync.WaitGroup) {
         defer wg.Done()
         a.status = "Initializing"
-        a.footer = "X - Exit     Esc - Go Back"
+        a.footer = "X - Exit     Esc - Go Back     S - Stats"
         a.currentData = make([]data.SymbolData, 0)
         a.minDataPointsToBuy = 30
 
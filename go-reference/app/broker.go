@@ -0,0 +1,70 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"astuart.co/go-robinhood"
+
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/services"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// Broker is what the day trader trades through, robinhood when trading live and a simulated broker when
+// replaying a past day
+type Broker interface {
+	Positions() ([]data.MyPosition, error)
+	BuyingPower() (decimal.Decimal, error) // cash that can be spent on new orders
+	Submit(order strategy.Order) error     // a limit order at the order's price
+}
+
+// wholeLots makes sure the day trader only trades whole shares, robinhood only takes stock orders for those
+func wholeLots(opts *data.DayTraderOptions) error {
+	if !opts.Lots.Whole() {
+		return errors.New("the day trader can only trade whole shares, robinhood takes no other stock orders")
+	}
+	return nil
+}
+
+// MarketFeed returns the analyzed bars from start to end of the symbols the day trader watches
+type MarketFeed func(start time.Time, end time.Time) []data.SymbolData
+
+// robinhoodBroker trades the app's robinhood account
+type robinhoodBroker struct {
+	app *App
+}
+
+func (b *robinhoodBroker) Positions() ([]data.MyPosition, error) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	positions, err := services.GetPositions(b.app.robinhoodClient, &wg, b.app.account)
+	wg.Wait()
+	return positions, err
+}
+
+func (b *robinhoodBroker) BuyingPower() (decimal.Decimal, error) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	account, err := services.GetMyAccount(b.app.robinhoodClient, &wg)
+	wg.Wait() // wait for account to be retrieved
+	b.app.account = account
+	fmt.Printf("account : %+v\n", account)
+	return decimal.NewFromFloat(math.Min(account.CashAvailableForWithdrawal, account.BuyingPower)), err
+}
+
+func (b *robinhoodBroker) Submit(order strategy.Order) error {
+	side := robinhood.Sell
+	if order.Buy {
+		side = robinhood.Buy
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	_, err := services.TradeQuantityAtPrice(b.app.robinhoodClient, &wg, b.app.DB, order.Symbol, order.Quantity, order.Price, side)
+	wg.Wait()
+	return err
+}
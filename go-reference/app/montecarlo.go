@@ -0,0 +1,95 @@
+package app
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/mcmohorn/market/server/backtest"
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/montecarlo"
+)
+
+// defaultRuinLevel counts a run as ruined once it has lost half its starting cash
+const defaultRuinLevel = 0.5
+
+// MonteCarloResult is the outcome of a monte carlo analysis
+type MonteCarloResult struct {
+	Base      backtest.Result   // the run whose trades are resampled
+	Bootstrap montecarlo.Result // of the base run's trades resampled
+	Perturbed montecarlo.Result // of the base run repeated with delayed entries and noisy fill prices
+	Seed      int64             // the analysis runs the same again with this seed
+}
+
+// RunMonteCarlo simulates the last bars of the data once and then tests how much luck was in the result,
+// both by resampling its trades in random orders and by repeating it with random entry delays and price noise
+func RunMonteCarlo(symbols []data.SymbolData, options *data.MonteCarloOptions) (MonteCarloResult, error) {
+	mc := MonteCarloResult{}
+	simulation := options.Simulation
+	ruinLevel := float64(options.RuinLevel)
+	if ruinLevel <= 0 {
+		ruinLevel = defaultRuinLevel
+	}
+
+	prepared := prepareSimulation(symbols, &simulation)
+	end := len(prepared.timeline)
+	start := end - simulation.NumberOfIntervals
+	if start < 0 || simulation.NumberOfIntervals <= 0 {
+		return mc, fmt.Errorf("not enough data for %v intervals", simulation.NumberOfIntervals)
+	}
+	rng, seed := newRand(simulation.Seed)
+	mc.Seed = seed
+	cash := decimal.NewFromFloat32(simulation.StartingCash)
+
+	mc.Base, _ = prepared.run(&simulation, newMACD(&simulation), start, end, cash)
+	returns := montecarlo.TradeReturns(mc.Base.Trades)
+	if len(returns) == 0 {
+		return mc, fmt.Errorf("the simulation made no trades to resample")
+	}
+	mc.Bootstrap = montecarlo.Summarize(montecarlo.Bootstrap(returns, cash.Float64(), options.Paths, rng), cash.Float64(), ruinLevel)
+
+	// each perturbed run draws its random numbers from its own seed, chosen up front so the seed decides
+	// them whatever order the runs finish in
+	days := make([]int, options.Paths)
+	seeds := make([]int64, options.Paths)
+	for p := range seeds {
+		days[p] = start
+		seeds[p] = rng.Int63()
+	}
+	runs, _ := prepared.runAll(&simulation, newMACD(&simulation), days, end-start, cash, func(p int, engine *backtest.Engine) {
+		engine.EntryDelay = options.EntryDelay
+		engine.PriceNoise = options.PriceNoise
+		engine.Rand = rand.New(rand.NewSource(seeds[p]))
+	})
+	paths := make([]montecarlo.Path, 0, options.Paths)
+	for _, run := range runs {
+		paths = append(paths, montecarlo.EquityPath(run.Equity))
+	}
+	mc.Perturbed = montecarlo.Summarize(paths, cash.Float64(), ruinLevel)
+
+	if options.HistogramFile != "" {
+		if err := PlotHistogram(mc.Bootstrap.Finals, "final equity of resampled trades", options.HistogramFile+"-bootstrap.png"); err != nil {
+			return mc, err
+		}
+		if err := PlotHistogram(mc.Perturbed.Finals, "final equity of perturbed runs", options.HistogramFile+"-perturbed.png"); err != nil {
+			return mc, err
+		}
+	}
+	return mc, nil
+}
+
+// PrintMonteCarlo prints the distributions of a monte carlo analysis
+func PrintMonteCarlo(mc MonteCarloResult) {
+	fmt.Printf("simulation ended with $%.0f after %v trades (seed %v)\n", mc.Base.FinalValue.Float64(), len(mc.Base.Trades), mc.Seed)
+	for _, r := range []struct {
+		name   string
+		result montecarlo.Result
+	}{{"resampled trades", mc.Bootstrap}, {"perturbed runs", mc.Perturbed}} {
+		fmt.Printf("\n%v (%v runs), %.1f%% ruined\n", r.name, len(r.result.Finals), 100*r.result.ProbabilityOfRuin)
+		fmt.Printf("%-13v %9v %9v %9v %9v %9v %9v\n", "", "mean", "5%", "25%", "50%", "75%", "95%")
+		e := r.result.FinalEquity
+		fmt.Printf("%-13v %9.0f %9.0f %9.0f %9.0f %9.0f %9.0f\n", "final equity", e.Mean, e.P5, e.P25, e.P50, e.P75, e.P95)
+		d := r.result.MaxDrawdown
+		fmt.Printf("%-13v %8.1f%% %8.1f%% %8.1f%% %8.1f%% %8.1f%% %8.1f%%\n", "max drawdown", 100*d.Mean, 100*d.P5, 100*d.P25, 100*d.P50, 100*d.P75, 100*d.P95)
+	}
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/mcmohorn/market/server/analyzer"
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// RunPairsSimulation backtests trading the spread of cointegrated pairs like RunStrategySimulation. Each
+// repetition finds pairs on the formation window right before its random start bar and then trades them
+// over the following bars, with the fills, costs, lot sizes and short rules of the simulation.
+func RunPairsSimulation(symbols []data.SymbolData, options *data.PairsOptions) (SimulationResult, error) {
+	simulation := options.Simulation
+	if len(symbols) < 2 {
+		return SimulationResult{}, fmt.Errorf("pairs trading needs at least two symbols")
+	}
+	if options.ZLookback > options.FormationIntervals {
+		return SimulationResult{}, fmt.Errorf("the z-score lookback of %v bars is longer than the formation window", options.ZLookback)
+	}
+	if !simulation.Shorts.Enabled {
+		return SimulationResult{}, fmt.Errorf("pairs trading sells short, so short selling has to be enabled")
+	}
+	simulation.WarmUp = options.FormationIntervals
+	return RunStrategySimulation(symbols, &simulation, func() strategy.Strategy {
+		return strategy.NewPairs(options)
+	})
+}
+
+// LatestPairs scans for cointegrated pairs on the last FormationIntervals bars of symbols, which have to
+// line up (see CleanDates)
+func LatestPairs(symbols []data.SymbolData, options *data.PairsOptions) ([]data.Pair, error) {
+	if len(symbols) < 2 {
+		return nil, fmt.Errorf("pairs trading needs at least two symbols")
+	}
+	latest := len(symbols[0].Bars)
+	if latest < options.FormationIntervals+1 {
+		return nil, fmt.Errorf("scanning for pairs needs at least %v bars, there are only %v", options.FormationIntervals+1, latest)
+	}
+	return analyzer.ScanPairs(symbols, latest-options.FormationIntervals, latest, options), nil
+}
+
+// PrintPairs prints the pairs found by a cointegration scan
+func PrintPairs(pairs []data.Pair) {
+	fmt.Printf("%-6v %-6v %8v %8v %9v %6v\n", "first", "second", "hedge", "adf", "halflife", "corr")
+	for _, p := range pairs {
+		fmt.Printf("%-6v %-6v %8.3f %8.2f %9.1f %6.2f\n", p.First, p.Second, p.HedgeRatio, p.ADF, p.HalfLife, p.Correlation)
+	}
+}
@@ -0,0 +1,295 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/mcmohorn/market/server/backtest"
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/helper"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// minuteSeconds is how long a minute bar lasts, it has closed once its time plus this has passed
+const minuteSeconds = 60
+
+// ReplayResult is what the day trader did over a replayed day
+type ReplayResult struct {
+	Start        int64 // time of the first decision
+	End          int64 // time of the last decision
+	Decisions    int   // times the trading routine ran
+	StartingCash decimal.Decimal
+	FinalValue   decimal.Decimal
+	Cash         decimal.Decimal
+	Shares       map[string]decimal.Decimal
+	WorkList     []backtest.WorkListItem
+	Fees         decimal.Decimal
+	Unfilled     []strategy.Order // orders still waiting to fill when the day ended
+}
+
+// RunReplay feeds the recorded minute bars of symbols through the day trader's trading routine on a
+// simulated clock that ticks every Interval seconds of the replayed day, trading with a simulated broker.
+// Each tick the routine only sees the bars that had closed by then, starting a day before like the live
+// routine pulls, so the bars should start a day before the replayed one. Replaying the same bars with the
+// same options always gives the same result.
+func RunReplay(symbols []data.SymbolData, options *data.ReplayOptions) (ReplayResult, error) {
+	result := ReplayResult{}
+	out := io.Writer(os.Stdout)
+	if options.Quiet {
+		out = io.Discard
+	}
+
+	sorted := make([]data.SymbolData, len(symbols))
+	copy(sorted, symbols)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Symbol < sorted[j].Symbol
+	})
+
+	// the session is every minute of the day that has a bar
+	year, month, day := options.Day.Date()
+	dayStart := time.Date(year, month, day, 0, 0, 0, 0, options.Day.Location()).Unix()
+	dayEnd := time.Date(year, month, day+1, 0, 0, 0, 0, options.Day.Location()).Unix()
+	first, last := int64(0), int64(0)
+	for _, s := range sorted {
+		for _, bar := range s.Bars {
+			if bar.Time < dayStart || bar.Time >= dayEnd {
+				continue
+			}
+			if first == 0 || bar.Time < first {
+				first = bar.Time
+			}
+			if bar.Time > last {
+				last = bar.Time
+			}
+		}
+	}
+	if first == 0 {
+		return result, fmt.Errorf("no minute bars on %v", options.Day.Format("01/02/06"))
+	}
+
+	dayTrader := options.DayTrader
+	if err := wholeLots(&dayTrader); err != nil {
+		return result, err
+	}
+	interval := int64(dayTrader.Interval)
+	if interval <= 0 {
+		interval = minuteSeconds
+	}
+	cash := decimal.NewFromFloat32(options.StartingCash)
+	broker := newSimulatedBroker(sorted, cash, backtest.NewCommissionModel(options.Commission), options.Fill)
+	trader := &App{dayTrader: strategy.NewDayTraderMACD(&dayTrader)}
+	analysisOptions := &data.AnalysisOptions{Timeframe: "minute"}
+	feed := func(start time.Time, end time.Time) []data.SymbolData {
+		return replayedData(sorted, start.Unix(), end.Unix(), analysisOptions)
+	}
+
+	result.Start = first + minuteSeconds
+	for now := result.Start; now <= last+minuteSeconds; now += interval {
+		broker.advance(now)
+		trader.tradingRoutine(&dayTrader, time.Unix(now, 0), feed, broker, out)
+		result.End = now
+		result.Decisions++
+	}
+	broker.advance(last + minuteSeconds)
+
+	result.StartingCash = cash
+	result.FinalValue = broker.portfolio.Value()
+	result.Cash = broker.portfolio.Cash
+	result.Shares = broker.portfolio.View().Shares
+	result.WorkList = broker.workList
+	result.Fees = broker.fees
+	for _, r := range broker.resting {
+		result.Unfilled = append(result.Unfilled, r.order)
+	}
+	if !options.Quiet {
+		PrintReplay(result, options.ShowWorkList)
+	}
+	return result, nil
+}
+
+// replayedData is what the live routine would have pulled from start to end, the bars of each symbol that
+// started at or after start and had closed by end, analyzed the same way
+func replayedData(symbols []data.SymbolData, start int64, end int64, opts *data.AnalysisOptions) []data.SymbolData {
+	replayed := make([]data.SymbolData, 0, len(symbols))
+	for _, s := range symbols {
+		from := sort.Search(len(s.Bars), func(i int) bool { return s.Bars[i].Time >= start })
+		to := sort.Search(len(s.Bars), func(i int) bool { return s.Bars[i].Time+minuteSeconds > end })
+		if from >= to {
+			continue
+		}
+		bars := make([]data.MyBar, to-from)
+		copy(bars, s.Bars[from:to])
+		if symbol, ok := newSymbolData(s.Symbol, analyzeBars(bars), opts); ok {
+			replayed = append(replayed, symbol)
+		}
+	}
+	return replayed
+}
+
+// PrintReplay prints what the day trader did over a replayed day
+func PrintReplay(result ReplayResult, showWorkList bool) {
+	fmt.Printf("%v - %v the day trader turned $%v into $%v in %v decisions and %v trades paying $%v in fees\n",
+		helper.PrettyTime2(result.Start), helper.PrettyTime2(result.End), result.StartingCash.StringFixed(2), result.FinalValue.StringFixed(2),
+		result.Decisions, len(result.WorkList), result.Fees.StringFixed(2))
+	if len(result.Unfilled) > 0 {
+		fmt.Printf("  %v orders were still waiting to fill at the end of the day\n", len(result.Unfilled))
+	}
+	if showWorkList {
+		PrintWorkLists([][]backtest.WorkListItem{result.WorkList})
+	}
+}
+
+// simulatedBroker fills the day trader's orders against the recorded bars like robinhood fills limit orders
+// that are good for the day, keeping its account in a backtest portfolio
+type simulatedBroker struct {
+	bars       map[string][]data.MyBar
+	portfolio  *backtest.Portfolio
+	commission backtest.CommissionModel
+	fills      data.FillAssumption
+	now        int64
+	resting    []restingOrder
+	workList   []backtest.WorkListItem
+	fees       decimal.Decimal
+}
+
+// restingOrder is an order waiting at the broker to fill
+type restingOrder struct {
+	order  strategy.Order
+	placed int64
+}
+
+func newSimulatedBroker(symbols []data.SymbolData, cash decimal.Decimal, commission backtest.CommissionModel, fills data.FillAssumption) *simulatedBroker {
+	b := &simulatedBroker{
+		bars:       make(map[string][]data.MyBar, len(symbols)),
+		portfolio:  backtest.NewPortfolio(cash),
+		commission: commission,
+		fills:      fills,
+	}
+	for _, s := range symbols {
+		b.bars[s.Symbol] = s.Bars
+	}
+	return b
+}
+
+func (b *simulatedBroker) Positions() ([]data.MyPosition, error) {
+	shares := b.portfolio.View()
+	positions := make([]data.MyPosition, 0, len(shares.Shares))
+	for _, symbol := range shares.Symbols() {
+		positions = append(positions, data.MyPosition{
+			Symbol:       symbol,
+			Quantity:     shares.Shares[symbol],
+			CurrentPrice: b.lastClose(symbol),
+		})
+	}
+	return positions, nil
+}
+
+// BuyingPower is the cash not set aside for the buy orders waiting to fill
+func (b *simulatedBroker) BuyingPower() (decimal.Decimal, error) {
+	power := b.portfolio.Cash
+	for _, r := range b.resting {
+		if r.order.Buy {
+			power = power - r.order.Quantity.Mul(r.order.Limit)
+		}
+	}
+	return power, nil
+}
+
+// Submit places a limit order at the order's price rounded to the cent, turning it down like robinhood
+// would if it is not for whole shares or there is not enough buying power or shares for it
+func (b *simulatedBroker) Submit(order strategy.Order) error {
+	if !order.Quantity.IsWhole() || order.Quantity <= 0 {
+		return fmt.Errorf("cannot order %v shares of %v, stock orders are for a whole number of shares", order.Quantity, order.Symbol)
+	}
+	order.Type = data.LimitOrder
+	order.Limit = data.WholeShares.RoundPrice(order.Price)
+	if order.Buy {
+		power, _ := b.BuyingPower()
+		if order.Quantity.Mul(order.Limit) > power {
+			return fmt.Errorf("not enough buying power for %v shares of %v", order.Quantity, order.Symbol)
+		}
+	} else {
+		available := b.portfolio.Shares[order.Symbol]
+		for _, r := range b.resting {
+			if !r.order.Buy && r.order.Symbol == order.Symbol {
+				available = available - r.order.Quantity
+			}
+		}
+		if order.Quantity > available {
+			return fmt.Errorf("not enough shares of %v to sell %v", order.Symbol, order.Quantity)
+		}
+	}
+	b.resting = append(b.resting, restingOrder{order: order, placed: b.now})
+	return nil
+}
+
+// advance moves the broker's clock to now, filling the orders reached by the bars that closed since they
+// were placed in the order they were placed and marking the portfolio to the latest closes
+func (b *simulatedBroker) advance(now int64) {
+	resting := b.resting[:0]
+	for _, r := range b.resting {
+		if !b.fill(r, now) {
+			resting = append(resting, r)
+		}
+	}
+	b.resting = resting
+	b.now = now
+	for symbol := range b.bars {
+		b.portfolio.UpdatePrice(symbol, data.WholeShares.Price(b.lastClose(symbol)))
+	}
+}
+
+// fill fills an order on the first bar placed after it that reaches its limit and had closed by now,
+// telling whether the order is done with
+func (b *simulatedBroker) fill(r restingOrder, now int64) bool {
+	bars := b.bars[r.order.Symbol]
+	for i := sort.Search(len(bars), func(i int) bool { return bars[i].Time >= r.placed }); i < len(bars) && bars[i].Time+minuteSeconds <= now; i++ {
+		open, high, low := backtest.BarRange(bars[i], data.WholeShares)
+		price, maker, ok := backtest.LimitFill(r.order, open, high, low, b.fills)
+		if !ok {
+			continue
+		}
+		f := backtest.Fill{
+			Symbol:   r.order.Symbol,
+			Buy:      r.order.Buy,
+			Quantity: r.order.Quantity,
+			Price:    price,
+			Maker:    maker,
+			Reason:   r.order.Reason,
+			Time:     bars[i].Time + minuteSeconds,
+		}
+		if b.commission != nil {
+			f.Fee = b.commission.Commission(f)
+		}
+		if !b.portfolio.CanFill(f) {
+			return true
+		}
+		b.portfolio.Apply(f)
+		b.fees = b.fees + f.Fee
+		b.workList = append(b.workList, backtest.WorkListItem{
+			Symbol:   f.Symbol,
+			Price:    f.Price,
+			Buy:      f.Buy,
+			Quantity: f.Quantity,
+			Fee:      f.Fee,
+			Reason:   f.Reason,
+			Time:     helper.PrettyTime2(f.Time),
+		})
+		return true
+	}
+	return false
+}
+
+// lastClose is the close of the last bar of a symbol that had closed by the broker's clock, 0 if none had
+func (b *simulatedBroker) lastClose(symbol string) float32 {
+	bars := b.bars[symbol]
+	i := sort.Search(len(bars), func(i int) bool { return bars[i].Time+minuteSeconds > b.now })
+	if i == 0 {
+		return 0
+	}
+	return bars[i-1].Close
+}
@@ -1,8 +1,7 @@
 package app
 
 import (
-	"math/rand"
-
+	"github.com/mcmohorn/market/server/backtest"
 	"github.com/mcmohorn/market/server/data"
 
 	"gonum.org/v1/plot"
@@ -13,8 +12,6 @@ import (
 
 // PlotBars will plot a given list of bars
 func PlotBars(bars []data.MyBar, symbol string) {
-	rand.Seed(int64(0))
-
 	p := plot.New()
 
 	p.Title.Text = symbol
@@ -59,3 +56,49 @@ func GetFASTMACD(bars []data.MyBar) plotter.XYs {
 	}
 	return pts
 }
+
+// PlotEquity plots the value and drawdown of a simulated portfolio to name-equity.png and name-drawdown.png
+func PlotEquity(equity []backtest.EquityPoint, name string) error {
+	values := make(plotter.XYs, len(equity))
+	drawdowns := make(plotter.XYs, len(equity))
+	for i, point := range equity {
+		values[i].X = float64(point.Time)
+		values[i].Y = point.Value.Float64()
+		drawdowns[i].X = float64(point.Time)
+		drawdowns[i].Y = -100 * float64(point.Drawdown)
+	}
+
+	p := plot.New()
+	p.Title.Text = name
+	p.X.Label.Text = "Date"
+	p.Y.Label.Text = "Value"
+	if err := plotutil.AddLines(p, "Value", values); err != nil {
+		return err
+	}
+	if err := p.Save(10*vg.Inch, 5*vg.Inch, name+"-equity.png"); err != nil {
+		return err
+	}
+
+	p = plot.New()
+	p.Title.Text = name
+	p.X.Label.Text = "Date"
+	p.Y.Label.Text = "Drawdown %"
+	if err := plotutil.AddLines(p, "Drawdown", drawdowns); err != nil {
+		return err
+	}
+	return p.Save(10*vg.Inch, 5*vg.Inch, name+"-drawdown.png")
+}
+
+// PlotHistogram plots the distribution of the values to a png
+func PlotHistogram(values []float64, title string, file string) error {
+	p := plot.New()
+	p.Title.Text = title
+	p.Y.Label.Text = "Runs"
+
+	h, err := plotter.NewHist(plotter.Values(values), 40)
+	if err != nil {
+		return err
+	}
+	p.Add(h)
+	return p.Save(8*vg.Inch, 5*vg.Inch, file)
+}
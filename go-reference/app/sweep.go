@@ -0,0 +1,272 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/indicators"
+	"github.com/mcmohorn/market/server/metrics"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/palette"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// SweepResult is the outcome of simulating one combination of a sweep
+type SweepResult struct {
+	Parameters data.SweepParameters
+	Metrics    data.PerformanceMetrics // averaged over the simulation's iterations
+	Score      float64                 // the sweep's metric, higher is better
+}
+
+// RunSweep simulates every combination of the sweep's parameter ranges on a pool of workers and returns
+// the results ranked by the sweep's metric, best first. Combinations run quietly and without checking
+// for look ahead or saving equity curves.
+func RunSweep(symbols []data.SymbolData, options *data.SweepOptions) ([]SweepResult, error) {
+	if _, err := metrics.Score(data.PerformanceMetrics{}, options.Metric); err != nil {
+		return nil, err
+	}
+	combinations := sweepCombinations(options)
+	if len(combinations) == 0 {
+		return nil, fmt.Errorf("the sweep has no valid combinations of parameters")
+	}
+
+	// every combination trades the same random windows
+	base := options.Simulation
+	_, base.Seed = newRand(base.Seed)
+	fmt.Printf("sweeping with seed %v\n", base.Seed)
+
+	// analyze the data once for each set of macd periods
+	analyzed := make(map[[3]int][]data.SymbolData)
+	for _, c := range combinations {
+		if _, ok := analyzed[periodsKey(c)]; !ok {
+			analyzed[periodsKey(c)] = analyzeWithPeriods(symbols, c.FastPeriod, c.SlowPeriod, c.SignalPeriod)
+		}
+	}
+
+	return rankParameters(combinations, options.Workers, func(params data.SweepParameters) (SweepResult, error) {
+		simulation := sweepSimulation(base, params)
+		result, err := RunSimulation(analyzed[periodsKey(params)], &simulation)
+		if err != nil {
+			return SweepResult{}, err
+		}
+		score, _ := metrics.Score(result.Average, options.Metric)
+		return SweepResult{Parameters: params, Metrics: result.Average, Score: score}, nil
+	}), nil
+}
+
+// rankParameters scores every combination on a pool of workers (one per cpu if workers is 0) and returns
+// them best first, leaving out the ones that could not be scored. Combinations with the same score keep
+// the order they were given in, so the ranking is the same however the workers finish.
+func rankParameters(combinations []data.SweepParameters, workers int, score func(params data.SweepParameters) (SweepResult, error)) []SweepResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	results := make([]SweepResult, len(combinations))
+	errors := make([]error, len(combinations))
+	jobs := make(chan int, len(combinations))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				results[c], errors[c] = score(combinations[c])
+			}
+		}()
+	}
+	for c := range combinations {
+		jobs <- c
+	}
+	close(jobs)
+	wg.Wait()
+
+	ranked := make([]SweepResult, 0, len(combinations))
+	for c, err := range errors {
+		if err != nil {
+			fmt.Printf("could not simulate %+v: %v\n", combinations[c], err)
+			continue
+		}
+		ranked = append(ranked, results[c])
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked
+}
+
+// sweepSimulation is the simulation of one combination of a sweep, run quietly without the extra checks
+// and output of a single simulation
+func sweepSimulation(base data.SimulationOptions, params data.SweepParameters) data.SimulationOptions {
+	simulation := base
+	simulation.MinBuySignal = params.MinBuySignal
+	simulation.MaxSharePrice = params.MaxSharePrice
+	simulation.MinCashLimit = params.MinCashLimit
+	simulation.FastPeriod = params.FastPeriod
+	simulation.SlowPeriod = params.SlowPeriod
+	simulation.SignalPeriod = params.SignalPeriod
+	simulation.Quiet = true
+	simulation.ShowWorkLists = false
+	simulation.CheckLookAhead = false
+	simulation.EquityFile = ""
+	simulation.TradesFile = ""
+	simulation.Workers = 1 // the sweep already runs a simulation on each of its workers
+	return simulation
+}
+
+// periodsKey identifies the macd periods of a combination
+func periodsKey(params data.SweepParameters) [3]int {
+	return [3]int{params.FastPeriod, params.SlowPeriod, params.SignalPeriod}
+}
+
+// sweepCombinations lists every combination of the sweep's ranges, skipping macd periods with the fast
+// period not shorter than the slow one
+func sweepCombinations(options *data.SweepOptions) []data.SweepParameters {
+	combinations := make([]data.SweepParameters, 0)
+	for _, minBuySignal := range options.MinBuySignal.Values(options.Simulation.MinBuySignal) {
+		for _, maxSharePrice := range options.MaxSharePrice.Values(options.Simulation.MaxSharePrice) {
+			for _, minCashLimit := range options.MinCashLimit.Values(options.Simulation.MinCashLimit) {
+				for _, fast := range options.FastPeriod.Values(indicators.DefaultFastPeriod) {
+					for _, slow := range options.SlowPeriod.Values(indicators.DefaultSlowPeriod) {
+						for _, signal := range options.SignalPeriod.Values(indicators.DefaultSignalPeriod) {
+							if fast >= slow || fast < 1 || signal < 1 {
+								continue
+							}
+							combinations = append(combinations, data.SweepParameters{
+								MinBuySignal:  minBuySignal,
+								MaxSharePrice: maxSharePrice,
+								MinCashLimit:  minCashLimit,
+								FastPeriod:    int(fast),
+								SlowPeriod:    int(slow),
+								SignalPeriod:  int(signal),
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+	return combinations
+}
+
+// analyzeWithPeriods analyzes the symbols again with the given macd periods
+func analyzeWithPeriods(symbols []data.SymbolData, fast int, slow int, signal int) []data.SymbolData {
+	if fast == indicators.DefaultFastPeriod && slow == indicators.DefaultSlowPeriod && signal == indicators.DefaultSignalPeriod {
+		return symbols
+	}
+	analyze := periodsAnalyzer(fast, slow, signal)
+	analyzed := make([]data.SymbolData, len(symbols))
+	for k, s := range symbols {
+		analyzed[k] = data.SymbolData{
+			Symbol: s.Symbol,
+			Bars:   analyze(s.Bars),
+		}
+	}
+	return analyzed
+}
+
+// sweepParameter returns the value of a parameter of a sweep by name
+func sweepParameter(p data.SweepParameters, name string) (float64, error) {
+	switch name {
+	case "minbuysignal":
+		return float64(p.MinBuySignal), nil
+	case "maxshareprice":
+		return float64(p.MaxSharePrice), nil
+	case "mincashlimit":
+		return float64(p.MinCashLimit), nil
+	case "fast":
+		return float64(p.FastPeriod), nil
+	case "slow":
+		return float64(p.SlowPeriod), nil
+	case "signal":
+		return float64(p.SignalPeriod), nil
+	}
+	return 0, fmt.Errorf("unknown sweep parameter %v", name)
+}
+
+// PrintSweep prints the top ranked results of a sweep (all of them if top is 0)
+func PrintSweep(results []SweepResult, metric string, top int) {
+	if top <= 0 || top > len(results) {
+		top = len(results)
+	}
+	fmt.Printf("%4v %9v %9v %9v %4v %4v %6v %10v %8v %7v %8v\n", "rank", "minbuy", "maxprice", "mincash", "fast", "slow", "signal", metric, "return", "sharpe", "drawdown")
+	for i, r := range results[:top] {
+		p := r.Parameters
+		fmt.Printf("%4v %9.2f %9.0f %9.0f %4v %4v %6v %10.3f %7.1f%% %7.2f %7.1f%%\n", i+1,
+			p.MinBuySignal, p.MaxSharePrice, p.MinCashLimit, p.FastPeriod, p.SlowPeriod, p.SignalPeriod,
+			r.Score, 100*r.Metrics.TotalReturn, r.Metrics.Sharpe, 100*r.Metrics.MaxDrawdown)
+	}
+}
+
+// sweepGrid is the best score of a sweep at each pair of values of two of its parameters
+type sweepGrid struct {
+	xs []float64
+	ys []float64
+	z  [][]float64 // indexed by x then y, NaN where nothing was simulated
+}
+
+func (g *sweepGrid) Dims() (int, int)   { return len(g.xs), len(g.ys) }
+func (g *sweepGrid) Z(c, r int) float64 { return g.z[c][r] }
+func (g *sweepGrid) X(c int) float64    { return g.xs[c] }
+func (g *sweepGrid) Y(r int) float64    { return g.ys[r] }
+
+// PlotSweepHeatmap plots the best score of the results at each value of parameters x and y to a png
+func PlotSweepHeatmap(results []SweepResult, metric string, x string, y string, file string) error {
+	xIndex := make(map[float64]int)
+	yIndex := make(map[float64]int)
+	grid := &sweepGrid{}
+	for _, r := range results {
+		xv, err := sweepParameter(r.Parameters, x)
+		if err != nil {
+			return err
+		}
+		yv, err := sweepParameter(r.Parameters, y)
+		if err != nil {
+			return err
+		}
+		if _, ok := xIndex[xv]; !ok {
+			xIndex[xv] = 0
+			grid.xs = append(grid.xs, xv)
+		}
+		if _, ok := yIndex[yv]; !ok {
+			yIndex[yv] = 0
+			grid.ys = append(grid.ys, yv)
+		}
+	}
+	sort.Float64s(grid.xs)
+	sort.Float64s(grid.ys)
+	for i, v := range grid.xs {
+		xIndex[v] = i
+	}
+	for i, v := range grid.ys {
+		yIndex[v] = i
+	}
+
+	grid.z = make([][]float64, len(grid.xs))
+	for i := range grid.z {
+		grid.z[i] = make([]float64, len(grid.ys))
+		for j := range grid.z[i] {
+			grid.z[i][j] = math.NaN()
+		}
+	}
+	for _, r := range results {
+		xv, _ := sweepParameter(r.Parameters, x)
+		yv, _ := sweepParameter(r.Parameters, y)
+		cell := &grid.z[xIndex[xv]][yIndex[yv]]
+		if math.IsNaN(*cell) || r.Score > *cell {
+			*cell = r.Score
+		}
+	}
+
+	p := plot.New()
+	p.Title.Text = metric
+	p.X.Label.Text = x
+	p.Y.Label.Text = y
+	p.Add(plotter.NewHeatMap(grid, palette.Heat(12, 1)))
+	return p.Save(8*vg.Inch, 8*vg.Inch, file)
+}
@@ -1,135 +1,153 @@
 package app
 
 import (
-	"github.com/mcmohorn/market/server/data"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"os"
+	"runtime"
 	"sort"
+	"sync"
 	"time"
 
+	"github.com/mcmohorn/market/server/analytics"
+	"github.com/mcmohorn/market/server/analyzer"
+	"github.com/mcmohorn/market/server/backtest"
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
 	"github.com/mcmohorn/market/server/helper"
+	"github.com/mcmohorn/market/server/indicators"
+	"github.com/mcmohorn/market/server/metrics"
+	"github.com/mcmohorn/market/server/strategy"
 )
 
-type WorkListItem struct {
-	Symbol   string
-	Price    float32
-	Buy      bool
-	Quantity int
-	Time     string
+// lookAheadCuts is how many points the data is cut off at when checking a strategy for look ahead bias
+const lookAheadCuts = 10
+
+// analyzeBars computes the indicators our strategies trade on
+func analyzeBars(bars []data.MyBar) []data.MyBar {
+	return indicators.CalculateRSI(indicators.CalculateMACD(bars))
+}
+
+// periodsAnalyzer analyzes bars like analyzeBars with the given macd periods, the defaults where they are 0
+func periodsAnalyzer(fast int, slow int, signal int) func(bars []data.MyBar) []data.MyBar {
+	if fast <= 0 {
+		fast = indicators.DefaultFastPeriod
+	}
+	if slow <= 0 {
+		slow = indicators.DefaultSlowPeriod
+	}
+	if signal <= 0 {
+		signal = indicators.DefaultSignalPeriod
+	}
+	return func(bars []data.MyBar) []data.MyBar {
+		return indicators.CalculateRSI(indicators.CalculateMACDPeriods(bars, fast, slow, signal))
+	}
+}
+
+// SimulationResult is the outcome of every repetition of a simulation
+type SimulationResult struct {
+	Runs           []backtest.Result
+	Metrics        []data.PerformanceMetrics // of each run
+	Average        data.PerformanceMetrics   // of all runs
+	LossPercent    float32                   // percent of runs that lost money
+	DoubledPercent float32                   // percent of runs that doubled their money
+	ExpectedReturn float32                   // expected growth of the starting cash from the average gain and loss
+	Seed           int64                     // the simulation runs the same again with this seed
+}
+
+// RunSimulation will run many simulations of the macd strategy on the given SymbolData
+func RunSimulation(data []data.SymbolData, options *data.SimulationOptions) (SimulationResult, error) {
+	return RunStrategySimulation(data, options, newMACD(options))
 }
 
-// RunSimulation will run many simulations on the given SymbolData
-func RunSimulation(data []data.SymbolData, options *data.SimulationOptions) {
+// RunStrategySimulation will run many simulations on the given SymbolData, each with a fresh strategy
+func RunStrategySimulation(data []data.SymbolData, options *data.SimulationOptions, newStrategy func() strategy.Strategy) (SimulationResult, error) {
 
 	repetitions := options.Iterations // how many times to repeat experiment
 
 	daysToTrade := options.NumberOfIntervals // how many days to trade in each repetition
 
-	startingCash := float32(options.StartingCash) 
+	startingCash := decimal.NewFromFloat32(options.StartingCash)
 
-	worklists := make([][]WorkListItem, 0)
+	out := io.Writer(os.Stdout)
+	if options.Quiet {
+		out = io.Discard
+	}
+
+	worklists := make([][]backtest.WorkListItem, 0)
+	rng, seed := newRand(options.Seed)
+	results := SimulationResult{Seed: seed}
+	fmt.Fprintf(out, "simulating with seed %v\n", seed)
 
 	losses := 0 // track how many times the algorithm lost equity over the given period
 	gains := 0
-	totalLost := float32(0) // track how many times the algorithm lost equity over the given period
-	totalGain := float32(0)
+	totalLost := decimal.Zero // track how many times the algorithm lost equity over the given period
+	totalGain := decimal.Zero
 	doubled := 0
-	minBuySignal := float32(options.MinBuySignal) // TODO was 4
-	maxSharePrice := float32(options.MaxSharePrice)
-	minCashLimit := float32(options.MinCashLimit)
-	cash := startingCash
-	shares := make(map[string]int, 0)
-
-	for r := 0; r < repetitions; r++ {
-		cash = startingCash
-		shares = make(map[string]int, 0)
-		// choose a random starting day, allowing length of trading period
-		day := rand.Intn(len(data[0].Bars) - daysToTrade - 1)
-
-		workList := make([]WorkListItem, 0)
-
-		for i := 0; i < daysToTrade ; i++ {
-			d := day + i
-			
-			sort.SliceStable(data, func(k, j int) bool {
-				return data[k].Bars[d].DiffAdjusted < data[j].Bars[d].DiffAdjusted
-			})
-			
-			bestIndex := -1
-			for j := 0; j < len(data); j++ {
-				if data[j].Bars[d].Diff > minBuySignal && data[j].Bars[d].Price < maxSharePrice && data[j].Bars[d].BuySignal {
-					bestIndex = j
-				}
-			}
 
+	// the timeline, breadth, regimes and benchmark are the same for every repetition
+	prepared := prepareSimulation(data, options)
+	regimes := prepared.regimes
+	timeline := prepared.timeline
+	regimeResults := newRegimePerformances()
+
+	if options.CheckLookAhead {
+		engine := prepared.newEngine(options, newStrategy(), startingCash)
+		analyze := periodsAnalyzer(options.FastPeriod, options.SlowPeriod, options.SignalPeriod)
+		if err := backtest.CheckLookAhead(engine, newStrategy, analyze, 0, len(timeline), lookAheadCuts); err != nil {
+			return results, err
+		}
+	}
 
-			if cash > minCashLimit && bestIndex > -1 {
-				// buy as much as we can of the good stuff if we have cash
-				canBuy := int(math.Floor(float64(cash / data[bestIndex].Bars[d].NextPrice)))
-
-				if canBuy > 0 {
-					shares[data[bestIndex].Symbol] = shares[data[bestIndex].Symbol] + canBuy
-					newWorkItem := WorkListItem{
-						Buy:      true,
-						Quantity: canBuy,
-						Symbol:   data[bestIndex].Symbol,
-						Price:    data[bestIndex].Bars[d].NextPrice,
-						Time:     helper.PrettyTime2(data[bestIndex].Bars[d].Bar.Time),
-					}
-					workList = append(workList, newWorkItem)
-					cash = cash - float32(canBuy)*data[bestIndex].Bars[d].NextPrice
-				}
+	// choose a random starting day for every repetition up front, allowing length of trading period and the
+	// warm up before it, so the seed decides them whatever order the repetitions finish in
+	choices := len(timeline) - daysToTrade - 1 - options.WarmUp
+	if choices <= 0 {
+		return results, fmt.Errorf("not enough data for %v intervals after a warm up of %v", daysToTrade, options.WarmUp)
+	}
+	days := make([]int, repetitions)
+	for r := range days {
+		days[r] = options.WarmUp + rng.Intn(choices)
+	}
+	runs, performances := prepared.runAll(options, newStrategy, days, daysToTrade, startingCash, nil)
 
+	// then add them up in order, as if they had run one after the other
+	for r := 0; r < repetitions; r++ {
+		day := days[r]
+		result, performance := runs[r], performances[r]
+		worklists = append(worklists, result.WorkList)
+		results.Runs = append(results.Runs, result)
+		results.Metrics = append(results.Metrics, performance)
+		if options.EquityFile != "" {
+			if err := SaveEquity(result.Equity, fmt.Sprintf("%v-%v", options.EquityFile, r)); err != nil {
+				fmt.Fprintf(out, "could not save the equity curve of trader %v: %v\n", r, err)
 			}
-
-			// check each of our holdings for sell signals
-			for key, num := range shares {
-				if num > 0 {
-					currIndex := -1
-					// find in data which symbol maches key
-					for i, v := range data {
-						if v.Symbol == key {
-							currIndex = i
-						}
-					}
-
-					if !data[currIndex].Bars[d].BuySignal {
-						// time to sell
-						cash = cash + float32(num)*data[currIndex].Bars[d].Price
-
-						w := WorkListItem{
-							Buy:      false,
-							Quantity: num,
-							Symbol:   data[currIndex].Symbol,
-							Price:    data[currIndex].Bars[d].Price,
-							Time:     helper.PrettyTime2(data[currIndex].Bars[d].Bar.Time),
-						}
-						workList = append(workList, w)
-						shares[key] = 0 
-					}
-				}
-
+		}
+		if options.TradesFile != "" {
+			if err := SaveTrades(result.Trades, fmt.Sprintf("%v-%v", options.TradesFile, r)); err != nil {
+				fmt.Fprintf(out, "could not save the trades of trader %v: %v\n", r, err)
 			}
 		}
-		worklists = append(worklists, workList)
 
-		totalAssets := cash
-		for key, num := range shares {
-
-			// find value of share
-			currIndex := 0
-			for i, v := range data {
-				if v.Symbol == key {
-					currIndex = i
-				}
-			}
+		// attribute each day's change in portfolio value to that day's regime
+		previousAssets := startingCash
+		for i, point := range result.Equity {
+			regimeResults[regimes[day+i]].add(float32(point.Value.Float64()/previousAssets.Float64() - 1))
+			previousAssets = point.Value
+		}
 
-			totalAssets = totalAssets + float32(num)*data[currIndex].Bars[len(data[currIndex].Bars)-1].Price
+		totalAssets := result.FinalValue
+		startTime := time.Unix(result.Start, 0)
+		endTime := time.Unix(result.End, 0)
+		fmt.Fprintf(out, "%v - %v turned $%v into $%v and %v paying $%v in fees, holding the benchmark made %.1f%% (trader %v)\n", startTime.Format("01/02/06"), endTime.Format("01/02/06"), startingCash, totalAssets.StringFixed(0), result.Shares, result.Fees.StringFixed(2), 100*performance.Benchmark.TotalReturn, r)
+		if options.Shorts.Enabled {
+			fmt.Fprintf(out, "  paid $%v to borrow shares sold short\n", result.BorrowCost.StringFixed(2))
+		}
+		if len(result.Expired) > 0 {
+			fmt.Fprintf(out, "  %v orders expired without filling\n", len(result.Expired))
 		}
-		startTime := time.Unix(data[0].Bars[day].Time, 0)
-		endTime := time.Unix(data[0].Bars[day+daysToTrade].Time, 0)
-		fmt.Printf("%v - %v turned $%v into $%.0f and %v (trader %v)\n", startTime.Format("01/02/06"), endTime.Format("01/02/06"), startingCash, totalAssets, shares, r)
 
 		if totalAssets < startingCash {
 			losses = losses + 1
@@ -137,46 +155,279 @@ func RunSimulation(data []data.SymbolData, options *data.SimulationOptions) {
 		} else if totalAssets > startingCash {
 			gains = gains + 1
 			totalGain += (totalAssets - startingCash)
-			if totalAssets > startingCash*2 {
+			if totalAssets > startingCash+startingCash {
 				doubled = doubled + 1
 			}
 		}
 
 	}
 
-	if options.ShowWorkLists {
+	if options.ShowWorkLists && !options.Quiet {
 		PrintWorkLists(worklists)
 	}
 	lossPercent := 100.0 * float32(losses) / float32(repetitions)
 	doubledPercent := 100.0 * float32(doubled) / float32(repetitions)
 
-	averageLossAmount := totalLost / float32(losses)
-	averageGainAmount := totalGain / float32(gains)
+	averageLossAmount := decimal.Zero
+	averageGainAmount := decimal.Zero
 
-	if losses == 0 {
-		averageLossAmount = 0
+	if losses > 0 {
+		averageLossAmount = totalLost.Div(decimal.New(int64(losses)))
+	}
+	if gains > 0 {
+		averageGainAmount = totalGain.Div(decimal.New(int64(gains)))
 	}
 
-	fmt.Printf(" - %.0f%% of the time\n", lossPercent)
-	fmt.Printf("x2 %.0f%% of the time\n", doubledPercent)
+	fmt.Fprintf(out, " - %.0f%% of the time\n", lossPercent)
+	fmt.Fprintf(out, "x2 %.0f%% of the time\n", doubledPercent)
 	//fmt.Printf("x10 tendies   %.2f of the time\n", skyrockettedPercent)
-	fmt.Printf("avg loss $%.0f\n", averageLossAmount)
-	fmt.Printf("avg gain $%.0f\n", averageGainAmount)
+	fmt.Fprintf(out, "avg loss $%v\n", averageLossAmount.StringFixed(0))
+	fmt.Fprintf(out, "avg gain $%v\n", averageGainAmount.StringFixed(0))
 
-	expectedAmount := lossPercent*averageLossAmount/100 + (1-lossPercent/100)*averageGainAmount
-	expectedReturn := (startingCash + expectedAmount) / startingCash
+	expectedAmount := averageLossAmount.Mul(decimal.NewFromFloat32(lossPercent/100)) + averageGainAmount.Mul(decimal.NewFromFloat32(1-lossPercent/100))
+	expectedReturn := (startingCash + expectedAmount).Div(startingCash).Float32()
 
-	fmt.Printf("Expected Return Rate of %1.0f%% after %v days\n", 100.0*(expectedReturn-1), daysToTrade)
-	
+	fmt.Fprintf(out, "Expected Return Rate of %1.0f%% after %v days\n", 100.0*(expectedReturn-1), daysToTrade)
+
+	results.Average = metrics.Average(results.Metrics)
+	results.LossPercent = lossPercent
+	results.DoubledPercent = doubledPercent
+	results.ExpectedReturn = expectedReturn
+	if !options.Quiet {
+		PrintMetrics(results.Average)
+		PrintRegimePerformance(regimeResults)
+	}
 
+	return results, nil
+}
+
+// newRand creates the random number generator of a simulation from its seed, picking a seed from the
+// clock if it is 0, and returns the seed used
+func newRand(seed int64) (*rand.Rand, int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed)), seed
+}
+
+// simulationData is what every run of a simulation shares. It is never modified once prepared, so runs
+// can share it from many goroutines at once.
+type simulationData struct {
+	symbols   []data.SymbolData
+	timeline  []int64
+	breadth   []data.Breadth
+	regimes   []data.Regime
+	benchmark []float64 // price of the benchmark at each time of the timeline
+}
+
+// prepareSimulation works out what every run of a simulation of the symbols shares
+func prepareSimulation(symbols []data.SymbolData, options *data.SimulationOptions) *simulationData {
+	// a copy of the list, so reordering the caller's one does not move symbols under the runs
+	symbols = append([]data.SymbolData(nil), symbols...)
+	d := &simulationData{
+		symbols:  symbols,
+		timeline: analyzer.Timeline(symbols),
+		breadth:  analyzer.CalculateBreadth(symbols),
+	}
+	d.regimes = analyzer.ClassifyRegimes(symbols, d.breadth, options.Regime)
+	d.benchmark = analyzer.BenchmarkPrices(symbols, options.Benchmark, d.timeline)
+	return d
+}
+
+// runAll runs a repetition of length bars starting at each of days on a pool of options.Workers workers
+// (one per cpu if 0) and returns their results in the order of days. setup (if given) changes the engine
+// of each repetition before it runs.
+func (d *simulationData) runAll(options *data.SimulationOptions, newStrategy func() strategy.Strategy, days []int, length int, cash decimal.Decimal, setup func(r int, engine *backtest.Engine)) ([]backtest.Result, []data.PerformanceMetrics) {
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(days) {
+		workers = len(days)
+	}
+	results := make([]backtest.Result, len(days))
+	performances := make([]data.PerformanceMetrics, len(days))
+	jobs := make(chan int, len(days))
+	for r := range days {
+		jobs <- r
+	}
+	close(jobs)
+
+	// each repetition writes only its own slot, so the results need no locking
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				engine := d.newEngine(options, newStrategy(), cash)
+				if setup != nil {
+					setup(r, engine)
+				}
+				results[r], performances[r] = d.measure(options, engine, days[r], days[r]+length, cash)
+			}
+		}()
+	}
+	wg.Wait()
+	return results, performances
+}
+
+// newEngine creates an engine set up for one run of the simulation
+func (d *simulationData) newEngine(options *data.SimulationOptions, s strategy.Strategy, cash decimal.Decimal) *backtest.Engine {
+	engine := backtest.NewEngine(d.symbols, d.timeline, s, cash)
+	engine.FillTiming = options.FillTiming
+	engine.Breadth = d.breadth
+	engine.Regimes = d.regimes
+	engine.Commission = backtest.NewCommissionModel(options.Commission)
+	engine.Slippage = backtest.NewSlippageModel(options.Slippage)
+	engine.Shorts = backtest.NewShortRules(options.Shorts, analytics.PeriodsPerYear(options.IntervalFormat == data.Minute, false))
+	engine.Lots = options.Lots
+	engine.Fills = options.Orders.Fill
+	return engine
+}
+
+// run trades a fresh strategy over [start, end) of the timeline starting with cash and measures how it did
+// against holding the benchmark
+func (d *simulationData) run(options *data.SimulationOptions, newStrategy func() strategy.Strategy, start int, end int, cash decimal.Decimal) (backtest.Result, data.PerformanceMetrics) {
+	return d.measure(options, d.newEngine(options, newStrategy(), cash), start, end, cash)
+}
+
+// measure runs an engine starting with cash over [start, end) of the timeline and measures how it did
+// against holding the benchmark
+func (d *simulationData) measure(options *data.SimulationOptions, engine *backtest.Engine, start int, end int, cash decimal.Decimal) (backtest.Result, data.PerformanceMetrics) {
+	periods := analytics.PeriodsPerYear(options.IntervalFormat == data.Minute, false)
+	result := engine.Run(start, end)
+	performance := metrics.Calculate(result, periods)
+	benchmark := metrics.PriceReturns(d.benchmark[start:end])
+	performance.Benchmark = metrics.Compare(metrics.Returns(cash.Float64(), result.Equity), benchmark, periods)
+	return result, performance
+}
+
+// SaveEquity writes an equity curve to name.csv and name.json and plots it next to them
+func SaveEquity(equity []backtest.EquityPoint, name string) error {
+	for _, export := range []struct {
+		extension string
+		write     func(w io.Writer, equity []backtest.EquityPoint) error
+	}{{".csv", backtest.WriteEquityCSV}, {".json", backtest.WriteEquityJSON}} {
+		err := writeFile(name+export.extension, func(w io.Writer) error {
+			return export.write(w, equity)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return PlotEquity(equity, name)
+}
+
+// SaveTrades writes round trips to name.csv and name.json and the statistics of each symbol traded to
+// name-symbols.csv and name-symbols.json
+func SaveTrades(trades []backtest.Trade, name string) error {
+	stats := backtest.StatsBySymbol(trades)
+	for _, export := range []struct {
+		file  string
+		write func(w io.Writer) error
+	}{
+		{name + ".csv", func(w io.Writer) error { return backtest.WriteTradesCSV(w, trades) }},
+		{name + ".json", func(w io.Writer) error { return backtest.WriteTradesJSON(w, trades) }},
+		{name + "-symbols.csv", func(w io.Writer) error { return backtest.WriteSymbolStatsCSV(w, stats) }},
+		{name + "-symbols.json", func(w io.Writer) error { return backtest.WriteSymbolStatsJSON(w, stats) }},
+	} {
+		if err := writeFile(export.file, export.write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile creates a file and writes it with write
+func writeFile(name string, write func(w io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// PrintMetrics prints the performance metrics of a simulation
+func PrintMetrics(m data.PerformanceMetrics) {
+	fmt.Printf("\ntotal return %.1f%%  cagr %.1f%%  volatility %.1f%%\n", 100*m.TotalReturn, 100*m.CAGR, 100*m.Volatility)
+	fmt.Printf("sharpe %.2f  sortino %.2f  calmar %.2f\n", m.Sharpe, m.Sortino, m.Calmar)
+	fmt.Printf("max drawdown %.1f%% lasting %v bars\n", 100*m.MaxDrawdown, m.MaxDrawdownDuration)
+	fmt.Printf("%v trades  win rate %.0f%%  profit factor %.2f  held %.1f bars  exposure %.0f%%\n",
+		m.Trades, 100*m.WinRate, m.ProfitFactor, m.AverageHolding, 100*m.Exposure)
+	fmt.Printf("benchmark return %.1f%%  excess %.1f%%  tracking error %.1f%%  information ratio %.2f  beta %.2f  alpha %.1f%%\n",
+		100*m.Benchmark.TotalReturn, 100*m.Benchmark.ExcessReturn, 100*m.Benchmark.TrackingError,
+		m.Benchmark.InformationRatio, m.Benchmark.Beta, 100*m.Benchmark.Alpha)
+}
+
+// regimePerformance accumulates the per bar portfolio returns seen while in one regime
+type regimePerformance struct {
+	bars      int
+	upBars    int
+	sum       float64
+	logGrowth float64
+}
+
+func newRegimePerformances() map[data.Regime]*regimePerformance {
+	results := make(map[data.Regime]*regimePerformance)
+	for _, regime := range data.Regimes {
+		results[regime] = &regimePerformance{}
+	}
+	return results
+}
+
+func (p *regimePerformance) add(r float32) {
+	p.bars++
+	if r > 0 {
+		p.upBars++
+	}
+	p.sum += float64(r)
+	p.logGrowth += math.Log1p(float64(r))
+}
+
+// PrintRegimePerformance prints how the simulated portfolio did while the market was in each regime
+func PrintRegimePerformance(results map[data.Regime]*regimePerformance) {
+	total := 0
+	for _, p := range results {
+		total += p.bars
+	}
+	if total == 0 {
+		return
+	}
+
+	fmt.Printf("\n%-9v %6v %6v %9v %8v %9v\n", "regime", "bars", "time", "avg/bar", "up bars", "compound")
+	for _, regime := range data.Regimes {
+		p := results[regime]
+		if p.bars == 0 {
+			fmt.Printf("%-9v %6v %5.0f%%\n", regime, 0, 0.0)
+			continue
+		}
+		fmt.Printf("%-9v %6v %5.0f%% %8.2f%% %7.0f%% %8.1f%%\n", regime, p.bars,
+			100.0*float32(p.bars)/float32(total),
+			100.0*p.sum/float64(p.bars),
+			100.0*float32(p.upBars)/float32(p.bars),
+			100.0*(math.Exp(p.logGrowth)-1))
+	}
 }
 
 // PrintWorkLists just prints out the work lists
-func PrintWorkLists(wls [][]WorkListItem) {
+func PrintWorkLists(wls [][]backtest.WorkListItem) {
 	for i, wl := range wls {
 		fmt.Printf("\nTrader: %v\n", i)
 		for _, item := range wl {
-			fmt.Printf("@ %v : %v %v shares of %v at $%.2f\n", item.Time, helper.PrettyBoughtMessage(item.Buy), item.Quantity, item.Symbol, item.Price)
+			action := helper.PrettyBoughtMessage(item.Buy)
+			if item.Short {
+				action = "shorted"
+			}
+			reason := ""
+			if item.Reason != "" {
+				reason = " on " + item.Reason
+			}
+			fmt.Printf("@ %v : %v %v shares of %v at $%v (fee $%v)%v\n", item.Time, action, item.Quantity, item.Symbol, item.Price.StringFixed(2), item.Fee.StringFixed(2), reason)
 		}
 
 	}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/mcmohorn/market/server/data"
 	"github.com/mcmohorn/market/server/helper"
 	"github.com/rivo/tview"
 )
@@ -110,12 +111,22 @@ func (a *App) TableKeys(event *tcell.EventKey) *tcell.EventKey {
 	case tcell.KeyEnter:
 		break
 	case tcell.KeyEscape:
+		if a.showingDetail {
+			a.showingDetail = false
+			a.DrawTable()
+			return nil
+		}
 		a.DrawWelcomeScreen()
 		return nil
 	case tcell.KeyRune:
 		switch event.Rune() {
 		case 'x':
 			a.StopGracefully()
+		case 's':
+			// toggle the statistics columns of the hot list
+			a.showStats = !a.showStats
+			a.UpdateTableData()
+			return nil
 		default:
 		}
 	}
@@ -148,6 +159,12 @@ func (a *App) DrawTable() {
 			a.viewTable.SetSelectable(true, true)
 		}
 	}).SetSelectedFunc(func(row int, column int) {
+		// selecting a symbol shows its details, selecting a header sorts by that column
+		if row > 0 && row <= len(a.currentData) {
+			a.DrawDetail(a.currentData[row-1])
+			return
+		}
+
 		if column == 0 {
 			a.sortSymbolAscending = !a.sortSymbolAscending
 			a.sortCurrentDataAlphabetically(a.sortSymbolAscending)
@@ -230,6 +247,51 @@ func (a *App) DrawTable() {
 
 }
 
+// DrawDetail draws the detail view for a single symbol
+func (a *App) DrawDetail(s data.SymbolData) {
+	a.showingDetail = true
+
+	text := fmt.Sprintf("%v  $%.2f  %v\n\n", s.Symbol, s.CurrentPrice, helper.PrettyBuy(s.CurrentBuySignal))
+	if len(s.Bars) > 0 {
+		lastBar := s.Bars[len(s.Bars)-1]
+		text += fmt.Sprintf("macd %.2f  (adj) %.4f  rsi %.0f\n\n", lastBar.MacdFast-lastBar.MacdSlow, lastBar.DiffAdjusted, lastBar.RSI)
+	}
+
+	lv := s.Levels
+	text += fmt.Sprintf("Pivot %.2f   R1 %.2f   R2 %.2f   S1 %.2f   S2 %.2f\n\n", lv.Pivot, lv.R1, lv.R2, lv.S1, lv.S2)
+	if lv.Resistance > 0 {
+		text += fmt.Sprintf("Resistance %.2f (%.1f%% above)\n", lv.Resistance, lv.ResistanceDistance)
+	} else {
+		text += "Resistance none found\n"
+	}
+	if lv.Support > 0 {
+		text += fmt.Sprintf("Support    %.2f (%.1f%% below)\n\n", lv.Support, lv.SupportDistance)
+	} else {
+		text += "Support    none found\n\n"
+	}
+
+	text += "Levels (touches)\n"
+	for _, c := range lv.Clusters {
+		text += fmt.Sprintf("%.2f (%v)\n", c.Price, c.Touches)
+	}
+	text += fmt.Sprintf("\n%v swing highs, %v swing lows", len(lv.SwingHighs), len(lv.SwingLows))
+
+	detail := tview.NewTextView().
+		SetTextAlign(tview.AlignLeft).
+		SetText(text)
+
+	grid := tview.NewGrid().
+		SetRows(2, 0, 1).
+		SetColumns(0).
+		SetBorders(false).
+		AddItem(a.statusText, 0, 0, 1, 1, 0, 0, false).
+		AddItem(detail, 1, 0, 1, 1, 0, 0, true).
+		AddItem(newPrimitive("Esc - Back to Hot List"), 2, 0, 1, 1, 0, 0, false)
+
+	a.baseGrid.Clear().AddItem(grid, 1, 1, 2, 2, 0, 0, true)
+	a.viewApp.SetFocus(detail)
+}
+
 func (a *App) DrawTableHeaders() {
 	a.viewTable.SetCell(0, 0, tview.NewTableCell(" Symbol ").SetTextColor(tcell.ColorBlue).SetAlign(tview.AlignLeft))
 	a.viewTable.SetCell(0, 1, tview.NewTableCell(" Action ").SetTextColor(tcell.ColorBlue).SetAlign(tview.AlignLeft))
@@ -239,6 +301,31 @@ func (a *App) DrawTableHeaders() {
 	a.viewTable.SetCell(0, 5, tview.NewTableCell(" (adj) ").SetTextColor(tcell.ColorBlue).SetAlign(tview.AlignLeft))
 	a.viewTable.SetCell(0, 6, tview.NewTableCell(" n ").SetTextColor(tcell.ColorBlue).SetAlign(tview.AlignCenter))
 	a.viewTable.SetCell(0, 7, tview.NewTableCell(" rsi ").SetTextColor(tcell.ColorBlue).SetAlign(tview.AlignLeft))
+	if a.showStats {
+		for i, header := range statsHeaders {
+			a.viewTable.SetCell(0, 8+i, tview.NewTableCell(header).SetTextColor(tcell.ColorBlue).SetAlign(tview.AlignLeft))
+		}
+	}
+}
+
+// statsHeaders are the optional hot list columns toggled with 's'
+var statsHeaders = []string{" hurst ", " vol ", " park ", " gk ", " ac1 ", " skew ", " kurt "}
+
+func (a *App) setStatsCells(row int, stats data.SymbolStats) {
+	hurstColor := tcell.ColorWhite
+	if stats.Hurst > 0.55 {
+		hurstColor = tcell.ColorGreen // trending
+	} else if stats.Hurst < 0.45 {
+		hurstColor = tcell.ColorYellow // mean reverting
+	}
+
+	a.viewTable.SetCell(row, 8, tview.NewTableCell(fmt.Sprintf("%.2f", stats.Hurst)).SetTextColor(hurstColor).SetAlign(tview.AlignCenter))
+	a.viewTable.SetCell(row, 9, tview.NewTableCell(fmt.Sprintf("%.0f%%", 100*stats.RealizedVolatility)).SetTextColor(tcell.ColorWhite).SetAlign(tview.AlignCenter))
+	a.viewTable.SetCell(row, 10, tview.NewTableCell(fmt.Sprintf("%.0f%%", 100*stats.ParkinsonVolatility)).SetTextColor(tcell.ColorWhite).SetAlign(tview.AlignCenter))
+	a.viewTable.SetCell(row, 11, tview.NewTableCell(fmt.Sprintf("%.0f%%", 100*stats.GarmanKlass)).SetTextColor(tcell.ColorWhite).SetAlign(tview.AlignCenter))
+	a.viewTable.SetCell(row, 12, tview.NewTableCell(fmt.Sprintf("%.2f", stats.Autocorrelation)).SetTextColor(tcell.ColorWhite).SetAlign(tview.AlignCenter))
+	a.viewTable.SetCell(row, 13, tview.NewTableCell(fmt.Sprintf("%.2f", stats.Skew)).SetTextColor(tcell.ColorWhite).SetAlign(tview.AlignCenter))
+	a.viewTable.SetCell(row, 14, tview.NewTableCell(fmt.Sprintf("%.1f", stats.Kurtosis)).SetTextColor(tcell.ColorWhite).SetAlign(tview.AlignCenter))
 }
 
 func (a *App) DrawPositionsTableHeaders() {
@@ -301,10 +388,10 @@ func (a *App) UpdatePositionsTableData() {
 
 		row := a.positionsTable.GetRowCount()
 		a.positionsTable.SetCell(row, 0, tview.NewTableCell(p.Symbol).SetTextColor(rowColor).SetAlign(tview.AlignLeft))
-		a.positionsTable.SetCell(row, 1, tview.NewTableCell(fmt.Sprintf("%.0f", p.Quantity)).SetTextColor(rowColor).SetAlign(tview.AlignRight))
+		a.positionsTable.SetCell(row, 1, tview.NewTableCell(p.Quantity.String()).SetTextColor(rowColor).SetAlign(tview.AlignRight))
 		a.positionsTable.SetCell(row, 2, tview.NewTableCell(fmt.Sprintf("%.2f", p.CurrentPrice)).SetTextColor(rowColor).SetAlign(tview.AlignRight))
-		sum = sum + (p.CurrentPrice * p.Quantity)
-		a.positionsTable.SetCell(row, 3, tview.NewTableCell(fmt.Sprintf("%.2f", p.CurrentPrice*p.Quantity)).SetTextColor(rowColor).SetAlign(tview.AlignRight))
+		sum = sum + (p.CurrentPrice * p.Quantity.Float32())
+		a.positionsTable.SetCell(row, 3, tview.NewTableCell(fmt.Sprintf("%.2f", p.CurrentPrice*p.Quantity.Float32())).SetTextColor(rowColor).SetAlign(tview.AlignRight))
 		if len(p.Data.Bars) > 0 {
 			if !p.Data.Bars[len(p.Data.Bars)-1].BuySignal {
 				rowColor = tcell.ColorRed
@@ -364,6 +451,10 @@ func (a *App) UpdateTableData() {
 			}
 
 			a.viewTable.SetCell(row, 7, tview.NewTableCell(fmt.Sprintf("%.0f", lastBar.RSI)).SetTextColor(rsiColor).SetAlign(tview.AlignCenter))
+
+			if a.showStats {
+				a.setStatsCells(row, s.Stats)
+			}
 		}
 
 	}
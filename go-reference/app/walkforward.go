@@ -0,0 +1,138 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mcmohorn/market/server/analytics"
+	"github.com/mcmohorn/market/server/backtest"
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/helper"
+	"github.com/mcmohorn/market/server/metrics"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// WalkForwardFold is one step of a walk forward optimization
+type WalkForwardFold struct {
+	Start       int64 // first bar of the in sample window
+	Split       int64 // first bar of the out of sample window
+	End         int64 // last bar of the out of sample window
+	Parameters  data.SweepParameters
+	InSample    data.PerformanceMetrics // of the chosen parameters
+	OutOfSample data.PerformanceMetrics
+}
+
+// WalkForwardResult is the outcome of a walk forward optimization
+type WalkForwardResult struct {
+	Folds   []WalkForwardFold
+	Equity  []backtest.EquityPoint // the out of sample windows stitched together
+	Metrics data.PerformanceMetrics
+}
+
+// RunWalkForward optimizes the sweep's parameters on a rolling in sample window and trades the best of them
+// on the out of sample window that follows, rolling forward by the out of sample length each time. Each out
+// of sample window starts flat with what the previous one ended with, so their equity curves stitch into
+// one that was never seen by the optimization.
+func RunWalkForward(symbols []data.SymbolData, options *data.WalkForwardOptions) (WalkForwardResult, error) {
+	wf := WalkForwardResult{}
+	sweep := &options.Sweep
+	if _, err := metrics.Score(data.PerformanceMetrics{}, sweep.Metric); err != nil {
+		return wf, err
+	}
+	if options.InSample <= 0 || options.OutOfSample <= 0 {
+		return wf, fmt.Errorf("walk forward windows must have bars")
+	}
+	combinations := sweepCombinations(sweep)
+	if len(combinations) == 0 {
+		return wf, fmt.Errorf("the sweep has no valid combinations of parameters")
+	}
+
+	// analyze and prepare the data once for each set of macd periods
+	prepared := make(map[[3]int]*simulationData)
+	for _, c := range combinations {
+		if _, ok := prepared[periodsKey(c)]; !ok {
+			analyzed := analyzeWithPeriods(symbols, c.FastPeriod, c.SlowPeriod, c.SignalPeriod)
+			prepared[periodsKey(c)] = prepareSimulation(analyzed, &sweep.Simulation)
+		}
+	}
+	timeline := prepared[periodsKey(combinations[0])].timeline
+	if len(timeline) < options.InSample+options.OutOfSample {
+		return wf, fmt.Errorf("not enough data for a %v bar in sample and %v bar out of sample window", options.InSample, options.OutOfSample)
+	}
+
+	startingCash := decimal.NewFromFloat32(sweep.Simulation.StartingCash)
+	cash := startingCash
+	trades := make([]backtest.Trade, 0)
+	for start := 0; start+options.InSample+options.OutOfSample <= len(timeline); start += options.OutOfSample {
+		split := start + options.InSample
+		end := split + options.OutOfSample
+
+		ranked := rankParameters(combinations, sweep.Workers, func(params data.SweepParameters) (SweepResult, error) {
+			simulation := sweepSimulation(sweep.Simulation, params)
+			_, performance := prepared[periodsKey(params)].run(&simulation, newMACD(&simulation), start, split, startingCash)
+			score, _ := metrics.Score(performance, sweep.Metric)
+			return SweepResult{Parameters: params, Metrics: performance, Score: score}, nil
+		})
+		if len(ranked) == 0 {
+			return wf, fmt.Errorf("no parameters could be scored on %v", helper.PrettyTime2(timeline[start]))
+		}
+		best := ranked[0]
+
+		simulation := sweepSimulation(sweep.Simulation, best.Parameters)
+		result, performance := prepared[periodsKey(best.Parameters)].run(&simulation, newMACD(&simulation), split, end, cash)
+		wf.Folds = append(wf.Folds, WalkForwardFold{
+			Start:       timeline[start],
+			Split:       timeline[split],
+			End:         timeline[end-1],
+			Parameters:  best.Parameters,
+			InSample:    best.Metrics,
+			OutOfSample: performance,
+		})
+		wf.Equity = append(wf.Equity, result.Equity...)
+		trades = append(trades, result.Trades...)
+		cash = result.FinalValue
+	}
+
+	// drawdowns of the stitched curve are measured from its own peaks rather than each window's
+	peak := startingCash
+	for i := range wf.Equity {
+		if wf.Equity[i].Value > peak {
+			peak = wf.Equity[i].Value
+		}
+		wf.Equity[i].Drawdown = 0
+		if peak > 0 {
+			wf.Equity[i].Drawdown = float32(1 - wf.Equity[i].Value.Float64()/peak.Float64())
+		}
+	}
+
+	periods := analytics.PeriodsPerYear(sweep.Simulation.IntervalFormat == data.Minute, false)
+	wf.Metrics = metrics.Calculate(backtest.Result{StartingCash: startingCash, Equity: wf.Equity, Trades: trades}, periods)
+	first := options.InSample
+	last := first + len(wf.Equity)
+	benchmark := metrics.PriceReturns(prepared[periodsKey(combinations[0])].benchmark[first:last])
+	wf.Metrics.Benchmark = metrics.Compare(metrics.Returns(startingCash.Float64(), wf.Equity), benchmark, periods)
+	return wf, nil
+}
+
+// newMACD makes the macd strategies of a simulation
+func newMACD(options *data.SimulationOptions) func() strategy.Strategy {
+	return func() strategy.Strategy {
+		return strategy.NewMACD(options)
+	}
+}
+
+// PrintWalkForward prints the parameters chosen for each fold and how they did out of sample
+func PrintWalkForward(wf WalkForwardResult, metric string) {
+	fmt.Printf("%-8v %-8v %-8v %7v %5v %5v %6v %10v %10v %8v\n", "start", "split", "end", "minbuy", "fast", "slow", "signal", "in "+metric, "out "+metric, "return")
+	for _, f := range wf.Folds {
+		in, _ := metrics.Score(f.InSample, metric)
+		out, _ := metrics.Score(f.OutOfSample, metric)
+		fmt.Printf("%-8v %-8v %-8v %7.2f %5v %5v %6v %10.3f %10.3f %7.1f%%\n",
+			time.Unix(f.Start, 0).Format("01/02/06"), time.Unix(f.Split, 0).Format("01/02/06"), time.Unix(f.End, 0).Format("01/02/06"),
+			f.Parameters.MinBuySignal, f.Parameters.FastPeriod, f.Parameters.SlowPeriod, f.Parameters.SignalPeriod,
+			in, out, 100*f.OutOfSample.TotalReturn)
+	}
+	fmt.Print("\nout of sample:")
+	PrintMetrics(wf.Metrics)
+}
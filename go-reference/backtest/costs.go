@@ -0,0 +1,172 @@
+package backtest
+
+import (
+	"math"
+
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// CommissionModel decides the fee charged for a fill
+type CommissionModel interface {
+	Commission(f Fill) decimal.Decimal
+}
+
+// SlippageModel decides the price (and how much of the quantity) an order really fills at on a bar
+type SlippageModel interface {
+	Slip(order strategy.Order, bar data.MyBar) (price decimal.Decimal, quantity decimal.Decimal)
+}
+
+// NewCommissionModel creates the commission model described by the options, nil when there are no fees
+func NewCommissionModel(opts data.CommissionOptions) CommissionModel {
+	var model CommissionModel
+	switch opts.Type {
+	case data.PerShareCommission:
+		model = &PerShare{Rate: decimal.NewFromFloat32(opts.Rate)}
+	case data.PerTradeCommission:
+		model = &PerTrade{Fee: decimal.NewFromFloat32(opts.Rate)}
+	case data.PercentCommission:
+		model = &Percent{Percent: opts.Rate}
+	case data.MakerTakerCommission:
+		model = &MakerTaker{MakerPercent: opts.MakerPercent, TakerPercent: opts.TakerPercent}
+	default:
+		return nil
+	}
+	if opts.Minimum > 0 || opts.Maximum > 0 {
+		model = &Bounded{Model: model, Minimum: decimal.NewFromFloat32(opts.Minimum), Maximum: decimal.NewFromFloat32(opts.Maximum)}
+	}
+	return model
+}
+
+// NewSlippageModel creates the slippage model described by the options, nil when fills are exact
+func NewSlippageModel(opts data.SlippageOptions) SlippageModel {
+	switch opts.Type {
+	case data.FixedSlippage:
+		return &Fixed{BasisPoints: opts.BasisPoints}
+	case data.SpreadSlippage:
+		return &Spread{BasisPoints: opts.BasisPoints, Fraction: opts.SpreadFraction}
+	case data.VolumeSlippage:
+		return &VolumeParticipation{BasisPoints: opts.BasisPoints, MaxParticipation: opts.MaxParticipation}
+	}
+	return nil
+}
+
+// PerShare charges a fixed amount for each share traded
+type PerShare struct {
+	Rate decimal.Decimal
+}
+
+func (c *PerShare) Commission(f Fill) decimal.Decimal {
+	return c.Rate.Mul(f.Quantity)
+}
+
+// PerTrade charges a fixed amount for each fill
+type PerTrade struct {
+	Fee decimal.Decimal
+}
+
+func (c *PerTrade) Commission(f Fill) decimal.Decimal {
+	return c.Fee
+}
+
+// Percent charges a percentage of the value traded
+type Percent struct {
+	Percent float32
+}
+
+func (c *Percent) Commission(f Fill) decimal.Decimal {
+	return percentOf(c.Percent, f.Price.Mul(f.Quantity))
+}
+
+// MakerTaker charges a percentage of the value traded that depends on whether the fill added or took liquidity
+type MakerTaker struct {
+	MakerPercent float32
+	TakerPercent float32
+}
+
+func (c *MakerTaker) Commission(f Fill) decimal.Decimal {
+	percent := c.TakerPercent
+	if f.Maker {
+		percent = c.MakerPercent
+	}
+	return percentOf(percent, f.Price.Mul(f.Quantity))
+}
+
+// Bounded keeps the fees of another model between a minimum and (if set) a maximum
+type Bounded struct {
+	Model   CommissionModel
+	Minimum decimal.Decimal
+	Maximum decimal.Decimal
+}
+
+func (c *Bounded) Commission(f Fill) decimal.Decimal {
+	fee := c.Model.Commission(f)
+	if fee < c.Minimum {
+		fee = c.Minimum
+	}
+	if c.Maximum > 0 && fee > c.Maximum {
+		fee = c.Maximum
+	}
+	return fee
+}
+
+// Fixed moves every fill a fixed number of basis points against us
+type Fixed struct {
+	BasisPoints float32
+}
+
+func (s *Fixed) Slip(order strategy.Order, bar data.MyBar) (decimal.Decimal, decimal.Decimal) {
+	return against(order, order.Price, percentOf(s.BasisPoints/100, order.Price)), order.Quantity
+}
+
+// Spread makes us pay half the spread, estimated as a fraction of the bar's range when the bar has a
+// high and low and as a fixed number of basis points of the price otherwise
+type Spread struct {
+	BasisPoints float32
+	Fraction    float32
+}
+
+func (s *Spread) Slip(order strategy.Order, bar data.MyBar) (decimal.Decimal, decimal.Decimal) {
+	spread := percentOf(s.BasisPoints/100, order.Price)
+	if s.Fraction > 0 && bar.High > bar.Low {
+		spread = decimal.NewFromFloat32(s.Fraction * (bar.High - bar.Low))
+	}
+	return against(order, order.Price, spread.Div(decimal.New(2))), order.Quantity
+}
+
+// VolumeParticipation moves the price against us by the square root of the fraction of the bar's volume we
+// trade (BasisPoints when trading all of it) and caps our quantity at MaxParticipation of the volume
+type VolumeParticipation struct {
+	BasisPoints      float32
+	MaxParticipation float32
+}
+
+func (s *VolumeParticipation) Slip(order strategy.Order, bar data.MyBar) (decimal.Decimal, decimal.Decimal) {
+	if bar.Volume <= 0 {
+		return order.Price, order.Quantity
+	}
+	quantity := order.Quantity
+	if s.MaxParticipation > 0 {
+		limit := decimal.NewFromFloat(float64(s.MaxParticipation) * float64(bar.Volume))
+		if quantity > limit {
+			quantity = limit
+		}
+	}
+	participation := quantity.Float64() / float64(bar.Volume)
+	impact := percentOf(s.BasisPoints/100*float32(math.Sqrt(participation)), order.Price)
+	return against(order, order.Price, impact), quantity
+}
+
+// against moves a price by amount in the direction that hurts the order (up for buys, down for sells)
+func against(order strategy.Order, price decimal.Decimal, amount decimal.Decimal) decimal.Decimal {
+	if order.Buy {
+		return price + amount
+	}
+	return price - amount
+}
+
+// percentOf is percent percent of an amount
+func percentOf(percent float32, amount decimal.Decimal) decimal.Decimal {
+	return amount.Mul(decimal.NewFromFloat(float64(percent) / 100))
+}
@@ -0,0 +1,591 @@
+// Package backtest runs strategies through an event driven simulation of the market
+package backtest
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/mcmohorn/market/server/analyzer"
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/helper"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// WorkListItem is a trade made during a backtest
+type WorkListItem struct {
+	Symbol   string
+	Price    decimal.Decimal
+	Buy      bool
+	Quantity decimal.Decimal
+	Fee      decimal.Decimal
+	Short    bool   // a short sale
+	Reason   string // why a position was closed, see strategy.Order
+	Time     string
+}
+
+// Trade is a round trip, shares bought and later sold, or sold short and later bought back. Profit is what
+// is left after the fees paid on both ends.
+type Trade struct {
+	Symbol     string          `json:"symbol"`
+	Quantity   decimal.Decimal `json:"quantity"`
+	Short      bool            `json:"short"`
+	EntryTime  int64           `json:"entryTime"`
+	ExitTime   int64           `json:"exitTime"`
+	EntryPrice decimal.Decimal `json:"entryPrice"` // average price the shares were bought at, or sold at when short
+	ExitPrice  decimal.Decimal `json:"exitPrice"`
+	Fees       decimal.Decimal `json:"fees"` // paid on the entry and exit of these shares
+	Profit     decimal.Decimal `json:"profit"`
+	Bars       int             `json:"bars"`   // bar times between the first buy and the sell
+	Reason     string          `json:"reason"` // why it was closed, see strategy.Order
+}
+
+// EquityPoint is the state of the portfolio after a bar time
+type EquityPoint struct {
+	Time     int64           `json:"time"`
+	Value    decimal.Decimal `json:"value"`
+	Cash     decimal.Decimal `json:"cash"`
+	Exposure float32         `json:"exposure"` // value of the long and short positions as a fraction of the portfolio value
+	Drawdown float32         `json:"drawdown"` // fraction the value is below its highest so far
+}
+
+// openPosition is what we paid for the shares of a symbol we hold, or got for the shares we are short
+type openPosition struct {
+	quantity decimal.Decimal // negative when short
+	cost     decimal.Decimal // paid for the shares when long, received for them when short
+	fees     decimal.Decimal // paid opening it
+	time     int64
+	n        int // position in the timeline of the first buy
+}
+
+// Engine feeds bars to a strategy in time order across all symbols, turning the orders it places into fills
+// against its portfolio. The symbols' bars do not have to line up and are never modified. The strategy
+// only ever sees bars up to the time it is deciding on, and its orders fill according to FillTiming.
+type Engine struct {
+	Data       []data.SymbolData
+	Timeline   []int64 // every bar time in the data, see analyzer.Timeline
+	Strategy   strategy.Strategy
+	Portfolio  *Portfolio
+	FillTiming data.FillTiming
+	Breadth    []data.Breadth  // breadth at each time of the timeline (optional)
+	Regimes    []data.Regime   // regime at each time of the timeline (optional)
+	Commission CommissionModel // fees charged on each fill (optional)
+	Slippage   SlippageModel   // how far fills move from the expected price (optional)
+	EntryDelay int             // buys wait up to this many extra bars at random before reaching the market (needs Rand)
+	PriceNoise float32         // standard deviation of random noise added to fill prices as a fraction of the price (needs Rand)
+	Rand       *rand.Rand
+	Shorts     *ShortRules         // allows selling short (optional)
+	Lots       data.Lots           // how finely each symbol trades, whole shares by default
+	Fills      data.FillAssumption // when a bar reaching the limit of an order fills it
+
+	queue     eventQueue
+	cursor    *analyzer.TimelineCursor
+	indexes   []int
+	visible   []data.SymbolData // the bars of each symbol up to the current time
+	pending   []pendingOrder    // orders waiting for a later bar of their symbol
+	workList  []WorkListItem
+	equity    []EquityPoint
+	trades    []Trade
+	positions map[string]*openPosition
+	expired   []ExpiredOrder
+	fees      decimal.Decimal
+	borrowed  decimal.Decimal
+	peak      decimal.Decimal
+}
+
+// pendingOrder is an order waiting for the bar it is filled on
+type pendingOrder struct {
+	order     strategy.Order
+	wait      int  // bars of its symbol to let pass first
+	expiry    int  // bars of its symbol left for a limit or stop order to fill on
+	triggered bool // the stop of a stop limit order was reached, so it waits on its limit
+}
+
+// ExpiredOrder is a limit or stop order that was cancelled when it did not fill in time
+type ExpiredOrder struct {
+	Order strategy.Order
+	Time  int64 // of the last bar it could have filled on
+}
+
+// Result is the outcome of running an engine over part of its timeline
+type Result struct {
+	Start        int64
+	End          int64
+	StartingCash decimal.Decimal
+	FinalValue   decimal.Decimal
+	Cash         decimal.Decimal
+	Shares       map[string]decimal.Decimal
+	WorkList     []WorkListItem
+	Equity       []EquityPoint   // after each bar time
+	Trades       []Trade         // round trips, the positions still open at the end closed at their last price (see strategy.ExitEnd)
+	Fees         decimal.Decimal // total commission paid
+	BorrowCost   decimal.Decimal // total paid to borrow the shares sold short
+	Expired      []ExpiredOrder  // limit and stop orders that never filled
+}
+
+// NewEngine creates an engine that trades the given symbols with a strategy starting from cash
+func NewEngine(symbols []data.SymbolData, timeline []int64, s strategy.Strategy, cash decimal.Decimal) *Engine {
+	return &Engine{
+		Data:      symbols,
+		Timeline:  timeline,
+		Strategy:  s,
+		Portfolio: NewPortfolio(cash),
+	}
+}
+
+// Run processes the bar times of the timeline in [start, end)
+func (e *Engine) Run(start int, end int) Result {
+	startingCash := e.Portfolio.Value()
+	e.cursor = analyzer.NewTimelineCursor(e.Data)
+	e.visible = make([]data.SymbolData, len(e.Data))
+	for k, s := range e.Data {
+		e.visible[k] = data.SymbolData{Symbol: s.Symbol}
+	}
+	e.pending = nil
+	e.workList = make([]WorkListItem, 0)
+	e.equity = make([]EquityPoint, 0, end-start)
+	e.trades = make([]Trade, 0)
+	e.expired = make([]ExpiredOrder, 0)
+	e.positions = make(map[string]*openPosition)
+	e.peak = startingCash
+	e.fees = 0
+	e.borrowed = 0
+
+	for n := start; n < end; n++ {
+		e.process(Event{Type: MarketEvent, Time: e.Timeline[n]}, n)
+		e.process(Event{Type: PortfolioEvent, Time: e.Timeline[n]}, n)
+	}
+	e.closeOpen(end - 1)
+
+	return Result{
+		Start:        e.Timeline[start],
+		End:          e.Timeline[end-1],
+		StartingCash: startingCash,
+		FinalValue:   e.Portfolio.Value(),
+		Cash:         e.Portfolio.Cash,
+		Shares:       e.Portfolio.View().Shares,
+		WorkList:     e.workList,
+		Equity:       e.equity,
+		Trades:       e.trades,
+		Fees:         e.fees,
+		BorrowCost:   e.borrowed,
+		Expired:      e.expired,
+	}
+}
+
+// process queues an event and handles it along with every event that results from it
+func (e *Engine) process(event Event, n int) {
+	e.queue.push(event)
+	for {
+		next, ok := e.queue.pop()
+		if !ok {
+			return
+		}
+		e.handle(next, n)
+	}
+}
+
+// handle processes one event, n is the position in the timeline being processed
+func (e *Engine) handle(event Event, n int) {
+	switch event.Type {
+	case MarketEvent:
+		e.indexes = e.cursor.Advance(event.Time)
+		for k, i := range e.indexes {
+			// until the strategy decides on the close, what we hold is worth what the orders reaching the
+			// market now pay
+			if i >= 0 {
+				e.Portfolio.UpdatePrice(e.Data[k].Symbol, e.marketPrice(e.Data[k].Symbol, e.Data[k].Bars[i]))
+			}
+			// cap the capacity too so the strategy cannot reslice its way into the future
+			seen := e.cursor.Seen(k)
+			e.visible[k].Bars = e.Data[k].Bars[:seen:seen]
+		}
+
+		// orders waiting on this bar of their symbol reach the market now, sells first to free up cash
+		pending := e.pending
+		e.pending = nil
+		for _, buy := range []bool{false, true} {
+			for _, p := range pending {
+				if p.order.Buy != buy {
+					continue
+				}
+				bar, ok := e.currentBar(p.order.Symbol)
+				if !ok || p.wait > 0 {
+					if ok {
+						p.wait--
+					}
+					e.pending = append(e.pending, p)
+					continue
+				}
+				order := p.order
+				if order.Type != data.MarketOrder {
+					price, maker, ok := e.trigger(&p, bar)
+					if ok {
+						order.Price = price
+						e.queue.push(Event{Type: OrderEvent, Time: event.Time, Order: order, Maker: maker})
+						continue
+					}
+					p.expiry--
+					if p.expiry > 0 {
+						e.pending = append(e.pending, p)
+					} else {
+						e.expired = append(e.expired, ExpiredOrder{Order: order, Time: event.Time})
+					}
+					continue
+				}
+				order.Price = e.marketPrice(order.Symbol, bar)
+				e.queue.push(Event{Type: OrderEvent, Time: event.Time, Order: order})
+			}
+		}
+		e.queue.push(Event{Type: MarginEvent, Time: event.Time})
+		e.queue.push(Event{Type: SignalEvent, Time: event.Time})
+
+	case MarginEvent:
+		e.marginCall(event.Time)
+
+	case SignalEvent:
+		for k, i := range e.indexes {
+			if i >= 0 {
+				e.Portfolio.UpdatePrice(e.Data[k].Symbol, e.price(e.Data[k].Symbol, e.Data[k].Bars[i].Price))
+			}
+		}
+		market := &strategy.MarketState{
+			Data:    e.visible,
+			Indexes: e.indexes,
+		}
+		if n < len(e.Breadth) {
+			market.Breadth = e.Breadth[n]
+		}
+		if n < len(e.Regimes) {
+			market.Regime = e.Regimes[n]
+		}
+		portfolio := e.Portfolio.View()
+		portfolio.Pending = make(map[string]bool, len(e.pending))
+		for _, p := range e.pending {
+			portfolio.Pending[p.order.Symbol] = true
+		}
+		for _, order := range sellsFirst(e.Strategy.OnBar(market, portfolio)) {
+			delay := 0
+			if order.Buy && e.EntryDelay > 0 {
+				delay = e.Rand.Intn(e.EntryDelay + 1)
+			}
+			if order.Type != data.MarketOrder {
+				// limit and stop orders wait for the bars after this one whatever the fill timing, replacing
+				// any the strategy placed on the symbol before
+				e.cancel(order.Symbol)
+				expiry := order.Expiry
+				if expiry <= 0 {
+					expiry = 1
+				}
+				e.pending = append(e.pending, pendingOrder{order: order, wait: delay, expiry: expiry})
+				continue
+			}
+			if e.FillTiming != data.SameClose {
+				e.pending = append(e.pending, pendingOrder{order: order, wait: delay})
+				continue
+			}
+			if delay > 0 {
+				// a delayed buy at the close fills at the close of a later bar
+				e.pending = append(e.pending, pendingOrder{order: order, wait: delay - 1})
+				continue
+			}
+			if bar, ok := e.currentBar(order.Symbol); ok {
+				order.Price = e.price(order.Symbol, bar.Price)
+				e.queue.push(Event{Type: OrderEvent, Time: event.Time, Order: order})
+			}
+		}
+
+	case OrderEvent:
+		// orders fill at their price, moved by slippage, as long as we can afford them
+		fill := e.fill(event.Order, event.Time, event.Maker)
+		marginCall := event.Order.Reason == strategy.ExitMarginCall
+		if marginCall || (e.Portfolio.CanFill(fill) && e.canShort(fill)) {
+			e.queue.pushFront(Event{Type: FillEvent, Time: event.Time, Fill: fill})
+		}
+
+	case FillEvent:
+		e.Portfolio.Apply(event.Fill)
+		e.workList = append(e.workList, WorkListItem{
+			Symbol:   event.Fill.Symbol,
+			Price:    event.Fill.Price,
+			Buy:      event.Fill.Buy,
+			Quantity: event.Fill.Quantity,
+			Fee:      event.Fill.Fee,
+			Short:    event.Fill.Short,
+			Reason:   event.Fill.Reason,
+			Time:     helper.PrettyTime2(event.Fill.Time),
+		})
+		e.fees = e.fees + event.Fill.Fee
+		e.track(event.Fill, n)
+
+	case PortfolioEvent:
+		if e.Shorts != nil {
+			borrow := e.Portfolio.ShortValue().Mul(decimal.NewFromFloat32(e.Shorts.BorrowCost))
+			e.Portfolio.Cash = e.Portfolio.Cash - borrow
+			e.borrowed = e.borrowed + borrow
+		}
+		value := e.Portfolio.Value()
+		point := EquityPoint{
+			Time:  event.Time,
+			Value: value,
+			Cash:  e.Portfolio.Cash,
+		}
+		if value > e.peak {
+			e.peak = value
+		}
+		if value > 0 {
+			point.Exposure = float32((e.Portfolio.LongValue() + e.Portfolio.ShortValue()).Float64() / value.Float64())
+		}
+		if e.peak > 0 {
+			point.Drawdown = float32(1 - value.Float64()/e.peak.Float64())
+		}
+		e.equity = append(e.equity, point)
+	}
+}
+
+// fill works out how an order would fill on the current bar after slippage and commission, cutting the
+// quantity of a buy until it is affordable with the fees included and of a short sale to what the margin
+// allows. Quantities are rounded down to the symbol's lot size, except for selling all that is held. Margin
+// calls are filled whatever they cost. Maker fills rested at their limit, so they do not slip.
+func (e *Engine) fill(order strategy.Order, t int64, maker bool) Fill {
+	f := Fill{
+		Symbol:   order.Symbol,
+		Buy:      order.Buy,
+		Quantity: order.Quantity,
+		Price:    order.Price,
+		Short:    order.Short,
+		Reason:   order.Reason,
+		Maker:    maker,
+		Time:     t,
+	}
+	if e.Slippage != nil && !maker {
+		if bar, ok := e.currentBar(order.Symbol); ok {
+			f.Price, f.Quantity = e.Slippage.Slip(order, bar)
+		}
+	}
+	if e.PriceNoise > 0 && !maker {
+		f.Price = f.Price.Mul(decimal.NewFromFloat(1 + float64(e.PriceNoise)*e.Rand.NormFloat64()))
+	}
+	f.Fee = e.fee(f)
+	if f.Price <= 0 || order.Reason == strategy.ExitMarginCall {
+		return f
+	}
+	lot := e.Lots.For(f.Symbol)
+	if !f.Buy {
+		held := e.Portfolio.Shares[f.Symbol]
+		if f.Short && e.Shorts != nil {
+			long := held
+			if long < 0 {
+				long = 0
+			}
+			if most := long + e.Shorts.MaxShort(e.Portfolio.Value()-f.Fee, e.Portfolio.ShortValue(), f.Price); f.Quantity > most {
+				f.Quantity = most
+			}
+		}
+		if f.Quantity != held {
+			f.Quantity = lot.Quantity(f.Quantity)
+		}
+		f.Fee = e.fee(f)
+		return f
+	}
+
+	if available := e.Portfolio.Available(f.Symbol, f.Quantity, f.Price); f.Quantity.Mul(f.Price)+f.Fee > available {
+		f.Quantity = (available - f.Fee).Div(f.Price)
+	}
+	f.Quantity = lot.Quantity(f.Quantity)
+	f.Fee = e.fee(f)
+	if f.Quantity > 0 && !e.Portfolio.CanFill(f) {
+		// the fees can still leave it short of cash, what a buy costs only grows with its quantity so search
+		// for the most lot steps that can be afforded rather than trying them one at a time
+		step := lot.Step()
+		steps := func(n int) Fill {
+			g := f
+			g.Quantity = lot.Quantity(step.Mul(decimal.New(int64(n))))
+			g.Fee = e.fee(g)
+			return g
+		}
+		f = steps(sort.Search(int(f.Quantity.Div(step).Int()), func(n int) bool {
+			return !e.Portfolio.CanFill(steps(n + 1))
+		}))
+	}
+	return f
+}
+
+// fee is the commission charged on a fill
+func (e *Engine) fee(f Fill) decimal.Decimal {
+	if e.Commission == nil {
+		return 0
+	}
+	return e.Commission.Commission(f)
+}
+
+// track keeps the cost of what we hold up to date with a fill, recording a trade for the shares sold or
+// bought back
+func (e *Engine) track(f Fill, n int) {
+	quantity := f.Quantity
+	if !f.Buy {
+		quantity = -quantity
+	}
+	fee := f.Fee
+	position, ok := e.positions[f.Symbol]
+	if ok && (position.quantity > 0) != (quantity > 0) {
+		// the fill closes as much of the position as it can
+		held := position.quantity.Abs()
+		closed := quantity.Abs()
+		if closed > held {
+			closed = held
+		}
+		closedFee := f.Fee.Mul(closed).Div(f.Quantity)
+		basis := position.cost.Mul(closed).Div(held)
+		entryFee := position.fees.Mul(closed).Div(held)
+		trade := position.close(f.Symbol, closed, f.Price, closedFee, f.Time, n, f.Reason)
+		if trade.Short {
+			position.quantity = position.quantity + closed
+			quantity = quantity - closed
+		} else {
+			position.quantity = position.quantity - closed
+			quantity = quantity + closed
+		}
+		e.trades = append(e.trades, trade)
+		position.cost = position.cost - basis
+		position.fees = position.fees - entryFee
+		fee = fee - closedFee
+		if position.quantity == 0 {
+			delete(e.positions, f.Symbol)
+			ok = false
+		}
+	}
+	if quantity == 0 {
+		return
+	}
+
+	// whatever is left opens or adds to a position
+	if !ok {
+		position = &openPosition{time: f.Time, n: n}
+		e.positions[f.Symbol] = position
+	}
+	position.quantity = position.quantity + quantity
+	position.cost = position.cost + quantity.Abs().Mul(f.Price)
+	position.fees = position.fees + fee
+}
+
+// close is the round trip of closing quantity of the position at price, paying fee on the way out, on
+// bar time t at position n of the timeline
+func (position *openPosition) close(symbol string, quantity decimal.Decimal, price decimal.Decimal, fee decimal.Decimal, t int64, n int, reason string) Trade {
+	held := position.quantity.Abs()
+	basis := position.cost.Mul(quantity).Div(held)
+	entryFee := position.fees.Mul(quantity).Div(held)
+	trade := Trade{
+		Symbol:     symbol,
+		Quantity:   quantity,
+		Short:      position.quantity < 0,
+		EntryTime:  position.time,
+		ExitTime:   t,
+		EntryPrice: position.cost.Div(held),
+		ExitPrice:  price,
+		Fees:       entryFee + fee,
+		Profit:     quantity.Mul(price) - basis - entryFee - fee,
+		Bars:       n - position.n,
+		Reason:     reason,
+	}
+	if trade.Short {
+		trade.Profit = basis - quantity.Mul(price) - entryFee - fee
+	}
+	return trade
+}
+
+// closeOpen records the positions still open after the last bar time, at position n of the timeline, as
+// round trips closed at their last price so the trades cover the whole run. They are not sold, so the
+// portfolio is left as it is.
+func (e *Engine) closeOpen(n int) {
+	symbols := make([]string, 0, len(e.positions))
+	for symbol := range e.positions {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		position := e.positions[symbol]
+		price := e.Portfolio.prices[symbol]
+		e.trades = append(e.trades, position.close(symbol, position.quantity.Abs(), price, 0, e.Timeline[n], n, strategy.ExitEnd))
+	}
+}
+
+// marginCall covers every short position with a bar at the time being processed when the portfolio has
+// fallen below the maintenance margin. It is checked once the orders waiting on the bars have filled, at
+// the prices they filled at, and the covers fill at those prices too, ahead of anything else queued.
+func (e *Engine) marginCall(t int64) {
+	if e.Shorts == nil || !e.Shorts.MarginCall(e.Portfolio.Value(), e.Portfolio.ShortValue()) {
+		return
+	}
+	covers := make([]Event, 0)
+	for k, i := range e.indexes {
+		symbol := e.Data[k].Symbol
+		if num := e.Portfolio.Shares[symbol]; num < 0 && i >= 0 {
+			covers = append(covers, Event{Type: OrderEvent, Time: t, Order: strategy.Order{
+				Symbol:   symbol,
+				Buy:      true,
+				Quantity: -num,
+				Price:    e.marketPrice(symbol, e.Data[k].Bars[i]),
+				Reason:   strategy.ExitMarginCall,
+			}})
+		}
+	}
+	for c := len(covers) - 1; c >= 0; c-- {
+		e.queue.pushFront(covers[c])
+	}
+}
+
+// canShort tells whether the short rules allow a fill, anything but a short sale is allowed
+func (e *Engine) canShort(f Fill) bool {
+	if !f.Short {
+		return true
+	}
+	return e.Shorts != nil && e.Shorts.Allows(f.Symbol, f.Price, e.previousClose(f.Symbol))
+}
+
+// currentBar returns the bar of a symbol at the time being processed
+func (e *Engine) currentBar(symbol string) (data.MyBar, bool) {
+	for k, i := range e.indexes {
+		if i >= 0 && e.Data[k].Symbol == symbol {
+			return e.Data[k].Bars[i], true
+		}
+	}
+	return data.MyBar{}, false
+}
+
+// previousClose returns the price of the bar of a symbol before the current one, 0 if there is none
+func (e *Engine) previousClose(symbol string) decimal.Decimal {
+	for k, i := range e.indexes {
+		if i > 0 && e.Data[k].Symbol == symbol {
+			return e.price(symbol, e.Data[k].Bars[i-1].Price)
+		}
+	}
+	return 0
+}
+
+// marketPrice is the price a market order reaching the market on a bar of a symbol fills at before
+// slippage, the open with NextOpen fill timing and the close otherwise
+func (e *Engine) marketPrice(symbol string, bar data.MyBar) decimal.Decimal {
+	if e.FillTiming == data.NextOpen && bar.Open > 0 {
+		return e.price(symbol, bar.Open)
+	}
+	return e.price(symbol, bar.Price)
+}
+
+// price turns a price of a symbol from its bars into one at the symbol's tick
+func (e *Engine) price(symbol string, p float32) decimal.Decimal {
+	return e.Lots.For(symbol).Price(p)
+}
+
+// sellsFirst orders sells ahead of buys so the cash they free up can be spent, keeping the order otherwise
+func sellsFirst(orders []strategy.Order) []strategy.Order {
+	sorted := make([]strategy.Order, 0, len(orders))
+	for _, buy := range []bool{false, true} {
+		for _, order := range orders {
+			if order.Buy == buy {
+				sorted = append(sorted, order)
+			}
+		}
+	}
+	return sorted
+}
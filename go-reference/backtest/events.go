@@ -0,0 +1,63 @@
+package backtest
+
+import (
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// EventType tells what an Event carries
+type EventType int
+
+const (
+	MarketEvent    EventType = iota // new bars are available at Time
+	SignalEvent                     // the strategy decides what to do on the bars at Time
+	OrderEvent                      // Order reached the market (or its limit or stop) and can be filled at its Price
+	FillEvent                       // the broker executed Fill
+	PortfolioEvent                  // the portfolio was marked to market at Time
+	MarginEvent                     // the orders waiting on the bars at Time have filled, so the margin can be checked
+)
+
+// Event is a single step of a backtest, the engine processes them in the order they were queued
+type Event struct {
+	Type  EventType
+	Time  int64
+	Order strategy.Order
+	Maker bool // Order rested at its limit before it was reached
+	Fill  Fill
+}
+
+// Fill is an executed trade
+type Fill struct {
+	Symbol   string
+	Buy      bool
+	Quantity decimal.Decimal
+	Price    decimal.Decimal // after slippage
+	Fee      decimal.Decimal
+	Maker    bool   // the fill added liquidity (a resting order) rather than taking it
+	Short    bool   // a sale of borrowed shares
+	Reason   string // why the order was made, see strategy.Order
+	Time     int64
+}
+
+// eventQueue is a first in first out queue of events
+type eventQueue struct {
+	events []Event
+}
+
+func (q *eventQueue) push(e Event) {
+	q.events = append(q.events, e)
+}
+
+// pushFront queues an event ahead of everything else, so a fill is applied before the next order is looked at
+func (q *eventQueue) pushFront(e Event) {
+	q.events = append([]Event{e}, q.events...)
+}
+
+func (q *eventQueue) pop() (Event, bool) {
+	if len(q.events) == 0 {
+		return Event{}, false
+	}
+	e := q.events[0]
+	q.events = q.events[1:]
+	return e, true
+}
@@ -0,0 +1,115 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WriteEquityCSV writes an equity curve as csv with a header row
+func WriteEquityCSV(w io.Writer, equity []EquityPoint) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"time", "value", "cash", "exposure", "drawdown"}); err != nil {
+		return err
+	}
+	for _, p := range equity {
+		err := writer.Write([]string{
+			strconv.FormatInt(p.Time, 10),
+			p.Value.StringFixed(2),
+			p.Cash.StringFixed(2),
+			fmt.Sprintf("%.4f", p.Exposure),
+			fmt.Sprintf("%.4f", p.Drawdown),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteEquityJSON writes an equity curve as a json array
+func WriteEquityJSON(w io.Writer, equity []EquityPoint) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(equity)
+}
+
+// WriteTradesCSV writes round trips as csv with a header row, holding periods in bars and seconds
+func WriteTradesCSV(w io.Writer, trades []Trade) error {
+	writer := csv.NewWriter(w)
+	header := []string{"symbol", "side", "entry_time", "exit_time", "entry_price", "exit_price", "quantity", "fees", "profit", "return", "bars", "seconds", "reason"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, t := range trades {
+		side := "long"
+		if t.Short {
+			side = "short"
+		}
+		err := writer.Write([]string{
+			t.Symbol,
+			side,
+			strconv.FormatInt(t.EntryTime, 10),
+			strconv.FormatInt(t.ExitTime, 10),
+			t.EntryPrice.String(),
+			t.ExitPrice.String(),
+			t.Quantity.String(),
+			t.Fees.String(),
+			t.Profit.String(),
+			fmt.Sprintf("%.4f", t.Return()),
+			strconv.Itoa(t.Bars),
+			strconv.FormatInt(t.ExitTime-t.EntryTime, 10),
+			t.Reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteTradesJSON writes round trips as a json array
+func WriteTradesJSON(w io.Writer, trades []Trade) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(trades)
+}
+
+// WriteSymbolStatsCSV writes the statistics of each symbol as csv with a header row
+func WriteSymbolStatsCSV(w io.Writer, stats []SymbolStats) error {
+	writer := csv.NewWriter(w)
+	header := []string{"symbol", "trades", "win_rate", "profit", "fees", "average_profit", "average_return", "best", "worst", "average_bars"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		err := writer.Write([]string{
+			s.Symbol,
+			strconv.Itoa(s.Trades),
+			fmt.Sprintf("%.4f", s.WinRate),
+			s.Profit.String(),
+			s.Fees.String(),
+			s.AverageProfit.StringFixed(2),
+			fmt.Sprintf("%.4f", s.AverageReturn),
+			s.Best.String(),
+			s.Worst.String(),
+			fmt.Sprintf("%.1f", s.AverageBars),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteSymbolStatsJSON writes the statistics of each symbol as a json array
+func WriteSymbolStatsJSON(w io.Writer, stats []SymbolStats) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stats)
+}
@@ -0,0 +1,118 @@
+package backtest
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/helper"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// CheckLookAhead makes sure strategies made by newStrategy only decide on bars they could have seen. It runs
+// one over [start, end) of the engine's timeline and then fresh ones over the same data cut off at a number
+// of points in between, with the engine's settings and starting cash. The cut off bars are passed through
+// analyze (if given) so indicators computed from the future show up too. A strategy whose orders before a
+// cut change when the bars after it are missing (or that reads past the bars it is given) gets an error.
+// Every run draws the same random numbers when the engine has Rand.
+func CheckLookAhead(e *Engine, newStrategy func() strategy.Strategy, analyze func(bars []data.MyBar) []data.MyBar, start int, end int, cuts int) error {
+	seed := int64(0)
+	if e.Rand != nil {
+		seed = e.Rand.Int63()
+	}
+	full, err := recordOrders(e, e.Data, newStrategy, start, end, seed)
+	if err != nil {
+		return err
+	}
+
+	for c := 1; c <= cuts; c++ {
+		cut := start + (end-start)*c/(cuts+1)
+		orders, err := recordOrders(e, truncateData(e.Data, e.Timeline[cut], analyze), newStrategy, start, cut+1, seed)
+		if err != nil {
+			return err
+		}
+		for n := range orders {
+			if !sameOrders(orders[n], full[n]) {
+				return fmt.Errorf("%v decided differently on %v when it could not see past %v, it is looking ahead",
+					e.Strategy.Name(), helper.PrettyTime2(e.Timeline[start+n]), helper.PrettyTime2(e.Timeline[cut]))
+			}
+		}
+	}
+	return nil
+}
+
+// orderRecorder passes a strategy's orders through, keeping the ones it placed on each bar
+type orderRecorder struct {
+	strategy.Strategy
+	orders [][]strategy.Order
+}
+
+func (r *orderRecorder) OnBar(market *strategy.MarketState, portfolio *strategy.Portfolio) []strategy.Order {
+	orders := r.Strategy.OnBar(market, portfolio)
+	r.orders = append(r.orders, orders)
+	return orders
+}
+
+// recordOrders runs a fresh strategy over the given symbols with every setting of engine e, and random
+// numbers drawn from seed, and returns the orders it placed on each bar
+func recordOrders(e *Engine, symbols []data.SymbolData, newStrategy func() strategy.Strategy, start int, end int, seed int64) (orders [][]strategy.Order, err error) {
+	recorder := &orderRecorder{Strategy: newStrategy()}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v read past the bars it was given: %v", recorder.Name(), r)
+		}
+	}()
+
+	engine := *e
+	engine.Data = symbols
+	engine.Strategy = recorder
+	engine.Portfolio = NewPortfolio(e.Portfolio.Cash)
+	engine.queue = eventQueue{}
+	if e.Rand != nil {
+		engine.Rand = rand.New(rand.NewSource(seed))
+	}
+	engine.Run(start, end)
+	return recorder.orders, nil
+}
+
+// truncateData returns the symbols with only their bars up to and including time t, analyzed again if analyze is set
+func truncateData(symbols []data.SymbolData, t int64, analyze func(bars []data.MyBar) []data.MyBar) []data.SymbolData {
+	truncated := make([]data.SymbolData, len(symbols))
+	for k, s := range symbols {
+		i := sort.Search(len(s.Bars), func(i int) bool {
+			return s.Bars[i].Time > t
+		})
+		truncated[k] = data.SymbolData{Symbol: s.Symbol, Bars: s.Bars[:i:i]}
+		if analyze != nil {
+			truncated[k].Bars = analyze(truncated[k].Bars)
+		}
+	}
+	return truncated
+}
+
+// sameOrders tells whether two sets of orders are the same regardless of the order they were placed in
+func sameOrders(a []strategy.Order, b []strategy.Order) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = sortedOrders(a)
+	b = sortedOrders(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedOrders(orders []strategy.Order) []strategy.Order {
+	sorted := append([]strategy.Order{}, orders...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Symbol != sorted[j].Symbol {
+			return sorted[i].Symbol < sorted[j].Symbol
+		}
+		return sorted[i].Buy && !sorted[j].Buy
+	})
+	return sorted
+}
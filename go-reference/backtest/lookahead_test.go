@@ -0,0 +1,146 @@
+package backtest
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/mcmohorn/market/server/analyzer"
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/indicators"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// analyze computes the indicators the macd strategy trades on
+func analyze(bars []data.MyBar) []data.MyBar {
+	return indicators.CalculateRSI(indicators.CalculateMACD(bars))
+}
+
+// randomWalks returns daily bars of a few symbols following random walks
+func randomWalks() []data.SymbolData {
+	rng := rand.New(rand.NewSource(7))
+	symbols := make([]data.SymbolData, 0)
+	for _, symbol := range []string{"AAA", "BBB", "CCC"} {
+		bars := make([]data.MyBar, 0)
+		price := float32(50)
+		for i := 0; i < 300; i++ {
+			bar := data.MyBar{}
+			bar.Time = int64(i * 86400)
+			bar.Open = price
+			price *= 1 + float32(rng.NormFloat64()*0.02)
+			bar.Close = price
+			bar.High = price * 1.01
+			bar.Low = price * 0.99
+			bar.Volume = 10000
+			bars = append(bars, bar)
+		}
+		symbols = append(symbols, data.SymbolData{Symbol: symbol, Bars: analyze(bars)})
+	}
+	return symbols
+}
+
+// peeker buys whichever symbol closes higher on the next bar, which it reads from its market
+type peeker struct{}
+
+func (p *peeker) Name() string {
+	return "peeker"
+}
+
+func (p *peeker) OnBar(market *strategy.MarketState, portfolio *strategy.Portfolio) []strategy.Order {
+	orders := make([]strategy.Order, 0)
+	for i := 0; i < market.Len(); i++ {
+		if !market.Has(i) {
+			continue
+		}
+		bars := market.Data[i].Bars
+		now := len(market.History(i)) - 1
+		if bars[now+1].Close > bars[now].Close && portfolio.Shares[market.Symbol(i)] == 0 {
+			orders = append(orders, strategy.Order{Symbol: market.Symbol(i), Buy: true, Quantity: decimal.One})
+		}
+	}
+	return orders
+}
+
+// leakyAnalyze analyzes bars like analyze, but also stores the move to the next close on each bar as its Diff,
+// like an indicator worked out with bars from the future would
+func leakyAnalyze(bars []data.MyBar) []data.MyBar {
+	bars = analyze(bars)
+	for i := range bars {
+		bars[i].Diff = 0
+		if i+1 < len(bars) {
+			bars[i].Diff = bars[i+1].Close - bars[i].Close
+		}
+	}
+	return bars
+}
+
+// follower holds whichever symbols have a positive Diff on their current bar, it only reads the bars it is
+// given but those carry the future when they were analyzed with leakyAnalyze
+type follower struct{}
+
+func (f *follower) Name() string {
+	return "follower"
+}
+
+func (f *follower) OnBar(market *strategy.MarketState, portfolio *strategy.Portfolio) []strategy.Order {
+	orders := make([]strategy.Order, 0)
+	for i := 0; i < market.Len(); i++ {
+		if !market.Has(i) {
+			continue
+		}
+		held := portfolio.Shares[market.Symbol(i)]
+		switch diff := market.Bar(i).Diff; {
+		case diff > 0 && held == 0:
+			orders = append(orders, strategy.Order{Symbol: market.Symbol(i), Buy: true, Quantity: decimal.One})
+		case diff < 0 && held > 0:
+			orders = append(orders, strategy.Order{Symbol: market.Symbol(i), Quantity: held})
+		}
+	}
+	return orders
+}
+
+func newTestEngine(symbols []data.SymbolData, s strategy.Strategy) *Engine {
+	engine := NewEngine(symbols, analyzer.Timeline(symbols), s, decimal.NewFromFloat(2000))
+	engine.FillTiming = data.NextOpen
+	return engine
+}
+
+func TestCheckLookAheadCatchesPeeking(t *testing.T) {
+	symbols := randomWalks()
+	newStrategy := func() strategy.Strategy { return &peeker{} }
+	engine := newTestEngine(symbols, newStrategy())
+
+	err := CheckLookAhead(engine, newStrategy, analyze, 0, len(engine.Timeline), 10)
+	if err == nil || !strings.Contains(err.Error(), "read past") {
+		t.Fatalf("a strategy reading the next bar was not caught reading past its bars: %v", err)
+	}
+}
+
+func TestCheckLookAheadCatchesLeakedFuture(t *testing.T) {
+	symbols := randomWalks()
+	for k := range symbols {
+		symbols[k].Bars = leakyAnalyze(symbols[k].Bars)
+	}
+	newStrategy := func() strategy.Strategy { return &follower{} }
+	engine := newTestEngine(symbols, newStrategy())
+
+	err := CheckLookAhead(engine, newStrategy, leakyAnalyze, 0, len(engine.Timeline), 10)
+	if err == nil || !strings.Contains(err.Error(), "looking ahead") {
+		t.Fatalf("a strategy trading on bars analyzed with the future was not caught deciding differently: %v", err)
+	}
+}
+
+func TestCheckLookAheadPassesMACD(t *testing.T) {
+	symbols := randomWalks()
+	options := &data.SimulationOptions{MaxSharePrice: 1000}
+	newStrategy := func() strategy.Strategy { return strategy.NewMACD(options) }
+	engine := newTestEngine(symbols, newStrategy())
+
+	if err := CheckLookAhead(engine, newStrategy, analyze, 0, len(engine.Timeline), 10); err != nil {
+		t.Fatalf("the macd strategy failed the look ahead check: %v", err)
+	}
+	if result := newTestEngine(symbols, newStrategy()).Run(0, len(engine.Timeline)); len(result.WorkList) == 0 {
+		t.Fatal("the macd strategy never traded, so the check tested nothing")
+	}
+}
@@ -0,0 +1,96 @@
+package backtest
+
+import (
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// trigger works out whether a limit or stop order fills on a bar of its symbol and at what price. Stops fill
+// at their stop, or at the open when the bar gaps past them, and limits as LimitFill says. A stop limit order
+// whose stop is reached fills right away if its limit allows it, otherwise it waits on its limit from the
+// next bar on, as there is no telling whether the bar came back to the limit after the stop.
+func (e *Engine) trigger(p *pendingOrder, bar data.MyBar) (price decimal.Decimal, maker bool, ok bool) {
+	order := p.order
+	open, high, low := BarRange(bar, e.Lots.For(order.Symbol))
+
+	if order.Type == data.StopOrder || (order.Type == data.StopLimitOrder && !p.triggered) {
+		switch {
+		case order.Buy && high >= order.Stop:
+			price = order.Stop
+			if open > price {
+				price = open
+			}
+		case !order.Buy && low <= order.Stop:
+			price = order.Stop
+			if open < price {
+				price = open
+			}
+		default:
+			return 0, false, false
+		}
+		if order.Type == data.StopOrder {
+			return price, false, true
+		}
+		p.triggered = true
+		if (order.Buy && price <= order.Limit) || (!order.Buy && price >= order.Limit) {
+			return price, false, true
+		}
+		return 0, false, false
+	}
+	return LimitFill(order, open, high, low, e.Fills)
+}
+
+// LimitFill works out whether a bar with the given open, high and low fills a limit order and at what price.
+// A bar that opens past the limit fills it at the open. One that reaches the limit later fills it at the
+// limit, which makes it a maker fill as the order rested there.
+func LimitFill(order strategy.Order, open decimal.Decimal, high decimal.Decimal, low decimal.Decimal, fills data.FillAssumption) (price decimal.Decimal, maker bool, ok bool) {
+	if (order.Buy && open < order.Limit) || (!order.Buy && open > order.Limit) {
+		return open, false, true
+	}
+	reached := (order.Buy && low <= order.Limit) || (!order.Buy && high >= order.Limit)
+	if fills == data.ThroughFill {
+		reached = (order.Buy && low < order.Limit) || (!order.Buy && high > order.Limit)
+	}
+	if reached {
+		return order.Limit, true, true
+	}
+	return 0, false, false
+}
+
+// BarRange returns the open, high and low of a bar at lot's tick, made up from the close for bars that do
+// not have them
+func BarRange(bar data.MyBar, lot data.LotSize) (open decimal.Decimal, high decimal.Decimal, low decimal.Decimal) {
+	last := bar.Price
+	if last <= 0 {
+		last = bar.Close
+	}
+	open, high, low = lot.Price(last), lot.Price(last), lot.Price(last)
+	if bar.Open > 0 {
+		open = lot.Price(bar.Open)
+	}
+	if bar.High > 0 {
+		high = lot.Price(bar.High)
+	}
+	if bar.Low > 0 {
+		low = lot.Price(bar.Low)
+	}
+	if open > high {
+		high = open
+	}
+	if open < low {
+		low = open
+	}
+	return open, high, low
+}
+
+// cancel drops the limit and stop orders waiting on a symbol
+func (e *Engine) cancel(symbol string) {
+	pending := e.pending[:0]
+	for _, p := range e.pending {
+		if p.order.Symbol != symbol || p.order.Type == data.MarketOrder {
+			pending = append(pending, p)
+		}
+	}
+	e.pending = pending
+}
@@ -0,0 +1,109 @@
+package backtest
+
+import (
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/strategy"
+)
+
+// Portfolio keeps track of the cash and shares of a backtest and the last price seen for each symbol. Shares
+// are negative for short positions.
+type Portfolio struct {
+	Cash   decimal.Decimal
+	Shares map[string]decimal.Decimal
+	prices map[string]decimal.Decimal
+}
+
+// NewPortfolio creates a portfolio holding only cash
+func NewPortfolio(cash decimal.Decimal) *Portfolio {
+	return &Portfolio{
+		Cash:   cash,
+		Shares: make(map[string]decimal.Decimal),
+		prices: make(map[string]decimal.Decimal),
+	}
+}
+
+// View is a copy of the portfolio for a strategy to look at
+func (p *Portfolio) View() *strategy.Portfolio {
+	shares := make(map[string]decimal.Decimal, len(p.Shares))
+	for symbol, num := range p.Shares {
+		shares[symbol] = num
+	}
+	return &strategy.Portfolio{Cash: p.Cash, Shares: shares}
+}
+
+// CanFill tells whether a fill can be made without borrowing cash, or borrowing shares unless it is a
+// short sale. The proceeds of short sales are held against the short positions, so only covering them frees
+// those up.
+func (p *Portfolio) CanFill(f Fill) bool {
+	if f.Quantity <= 0 {
+		return false
+	}
+	if f.Buy {
+		return f.Quantity.Mul(f.Price)+f.Fee <= p.Available(f.Symbol, f.Quantity, f.Price)
+	}
+	return f.Short || p.Shares[f.Symbol] >= f.Quantity
+}
+
+// Available is the cash that can be spent on buying quantity shares of symbol at price, the cash not held
+// against short positions plus what covering the symbol's short position frees up
+func (p *Portfolio) Available(symbol string, quantity decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	available := p.Cash - p.ShortValue()
+	if short := -p.Shares[symbol]; short > 0 {
+		if quantity > short {
+			quantity = short
+		}
+		available = available + quantity.Mul(price)
+	}
+	return available
+}
+
+// Apply updates cash and shares with a fill
+func (p *Portfolio) Apply(f Fill) {
+	if f.Buy {
+		p.Shares[f.Symbol] = p.Shares[f.Symbol] + f.Quantity
+		p.Cash = p.Cash - f.Quantity.Mul(f.Price)
+	} else {
+		p.Shares[f.Symbol] = p.Shares[f.Symbol] - f.Quantity
+		p.Cash = p.Cash + f.Quantity.Mul(f.Price)
+	}
+	p.Cash = p.Cash - f.Fee
+	if p.Shares[f.Symbol] == 0 {
+		delete(p.Shares, f.Symbol)
+	}
+}
+
+// UpdatePrice records the latest price of a symbol for marking the portfolio to market
+func (p *Portfolio) UpdatePrice(symbol string, price decimal.Decimal) {
+	p.prices[symbol] = price
+}
+
+// Value is the cash plus every holding at its last seen price, less what it takes to cover the shorts
+func (p *Portfolio) Value() decimal.Decimal {
+	total := p.Cash
+	for symbol, num := range p.Shares {
+		total = total + num.Mul(p.prices[symbol])
+	}
+	return total
+}
+
+// LongValue is the value of the shares held at their last seen price
+func (p *Portfolio) LongValue() decimal.Decimal {
+	total := decimal.Zero
+	for symbol, num := range p.Shares {
+		if num > 0 {
+			total = total + num.Mul(p.prices[symbol])
+		}
+	}
+	return total
+}
+
+// ShortValue is what it would cost to buy back the shares we are short at their last seen price
+func (p *Portfolio) ShortValue() decimal.Decimal {
+	total := decimal.Zero
+	for symbol, num := range p.Shares {
+		if num < 0 {
+			total = total - num.Mul(p.prices[symbol])
+		}
+	}
+	return total
+}
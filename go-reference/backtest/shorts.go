@@ -0,0 +1,77 @@
+package backtest
+
+import (
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+)
+
+// Default margins of short positions, as fractions of their value
+const (
+	DefaultInitialMargin     = 0.5
+	DefaultMaintenanceMargin = 0.3
+)
+
+// ShortRules are what the broker asks of short sales. An engine without them never lets a sell go beyond
+// the shares held.
+type ShortRules struct {
+	BorrowCost        float32 // fraction of the value of the short positions paid each bar to borrow the shares
+	InitialMargin     float32 // fraction of the value of the short positions the equity must cover after a short sale
+	MaintenanceMargin float32 // fraction of the value of the short positions the equity must stay above or they are covered
+	UptickRule        bool    // short sales only fill above the previous close of their symbol
+	HardToBorrow      map[string]bool
+}
+
+// NewShortRules creates the rules for short selling from the simulation options, nil if short selling is not
+// enabled. The yearly borrow rate is spread over periodsPerYear bars.
+func NewShortRules(opts data.ShortOptions, periodsPerYear float64) *ShortRules {
+	if !opts.Enabled {
+		return nil
+	}
+	rules := &ShortRules{
+		InitialMargin:     DefaultInitialMargin,
+		MaintenanceMargin: DefaultMaintenanceMargin,
+		UptickRule:        opts.UptickRule,
+		HardToBorrow:      make(map[string]bool),
+	}
+	if periodsPerYear > 0 {
+		rules.BorrowCost = opts.BorrowRate / 100 / float32(periodsPerYear)
+	}
+	if opts.InitialMargin > 0 {
+		rules.InitialMargin = opts.InitialMargin / 100
+	}
+	if opts.MaintenanceMargin > 0 {
+		rules.MaintenanceMargin = opts.MaintenanceMargin / 100
+	}
+	for _, symbol := range opts.HardToBorrow {
+		rules.HardToBorrow[symbol] = true
+	}
+	return rules
+}
+
+// MaxShort is how many more shares can be sold short at price while keeping the initial margin on a
+// portfolio worth equity that is already short shortValue
+func (r *ShortRules) MaxShort(equity decimal.Decimal, shortValue decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	if price <= 0 || r.InitialMargin <= 0 {
+		return 0
+	}
+	room := equity.Div(decimal.NewFromFloat32(r.InitialMargin)) - shortValue
+	if room <= 0 {
+		return 0
+	}
+	return room.Div(price)
+}
+
+// Allows tells whether a short sale may be made at price given the previous close of its symbol (0 if
+// there is none)
+func (r *ShortRules) Allows(symbol string, price decimal.Decimal, previous decimal.Decimal) bool {
+	if r.HardToBorrow[symbol] {
+		return false
+	}
+	return !r.UptickRule || (previous > 0 && price > previous)
+}
+
+// MarginCall tells whether a portfolio worth equity has fallen below the maintenance margin of its short
+// positions worth shortValue
+func (r *ShortRules) MarginCall(equity decimal.Decimal, shortValue decimal.Decimal) bool {
+	return shortValue > 0 && equity < shortValue.Mul(decimal.NewFromFloat32(r.MaintenanceMargin))
+}
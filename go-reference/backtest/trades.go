@@ -0,0 +1,73 @@
+package backtest
+
+import (
+	"sort"
+
+	"github.com/mcmohorn/market/server/decimal"
+)
+
+// SymbolStats sum up the round trips of a single symbol
+type SymbolStats struct {
+	Symbol        string          `json:"symbol"`
+	Trades        int             `json:"trades"`
+	WinRate       float64         `json:"winRate"` // fraction of round trips closed at a profit
+	Profit        decimal.Decimal `json:"profit"`  // after fees
+	Fees          decimal.Decimal `json:"fees"`
+	AverageProfit decimal.Decimal `json:"averageProfit"`
+	AverageReturn float64         `json:"averageReturn"` // of each round trip on what was paid for it
+	Best          decimal.Decimal `json:"best"`          // most made on a round trip
+	Worst         decimal.Decimal `json:"worst"`         // least made (most lost) on a round trip
+	AverageBars   float64         `json:"averageBars"`   // bars each round trip was held on average
+}
+
+// Return is the profit of a round trip over what the shares were entered at, 0 if that is nothing
+func (t Trade) Return() float64 {
+	cost := t.EntryPrice.Mul(t.Quantity)
+	if cost <= 0 {
+		return 0
+	}
+	return t.Profit.Float64() / cost.Float64()
+}
+
+// StatsBySymbol sums up the round trips of each symbol traded, in alphabetical order of symbol
+func StatsBySymbol(trades []Trade) []SymbolStats {
+	bySymbol := make(map[string]*SymbolStats)
+	symbols := make([]string, 0)
+	wins := make(map[string]int)
+	returns := make(map[string]float64)
+	bars := make(map[string]int)
+	for _, t := range trades {
+		s, ok := bySymbol[t.Symbol]
+		if !ok {
+			s = &SymbolStats{Symbol: t.Symbol, Best: t.Profit, Worst: t.Profit}
+			bySymbol[t.Symbol] = s
+			symbols = append(symbols, t.Symbol)
+		}
+		s.Trades++
+		s.Profit = s.Profit + t.Profit
+		s.Fees = s.Fees + t.Fees
+		if t.Profit > s.Best {
+			s.Best = t.Profit
+		}
+		if t.Profit < s.Worst {
+			s.Worst = t.Profit
+		}
+		if t.Profit > 0 {
+			wins[t.Symbol]++
+		}
+		returns[t.Symbol] += t.Return()
+		bars[t.Symbol] += t.Bars
+	}
+
+	sort.Strings(symbols)
+	stats := make([]SymbolStats, 0, len(symbols))
+	for _, symbol := range symbols {
+		s := bySymbol[symbol]
+		s.WinRate = float64(wins[symbol]) / float64(s.Trades)
+		s.AverageProfit = s.Profit.Div(decimal.New(int64(s.Trades)))
+		s.AverageReturn = returns[symbol] / float64(s.Trades)
+		s.AverageBars = float64(bars[symbol]) / float64(s.Trades)
+		stats = append(stats, *s)
+	}
+	return stats
+}
@@ -5,6 +5,7 @@ import (
 
 	"astuart.co/go-robinhood"
 	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
+	"github.com/mcmohorn/market/server/decimal"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -17,12 +18,11 @@ type MyBar struct {
 	MacdSlow         float32
 	BuySignal        bool
 	BuySignalChanged []int64
-	Shares           int
+	Shares           decimal.Decimal
 	Cash             float64
 	Diff             float32
 	DiffAdjusted     float32
 	Price            float32
-	NextPrice        float32
 	T                int64
 	SMMAU            float32
 	SMMAD            float32
@@ -32,7 +32,7 @@ type MyBar struct {
 // MyPosition is like a robinhood position unified with a robinhood quote
 type MyPosition struct {
 	Symbol        string
-	Quantity      float32
+	Quantity      decimal.Decimal
 	CurrentPrice  float32
 	Instrument    *robinhood.Instrument
 	Data          SymbolData
@@ -61,6 +61,69 @@ type SymbolData struct {
 	Bars             []MyBar
 	CurrentBuySignal bool
 	CurrentPrice     float32
+	Levels           Levels
+	Stats            SymbolStats
+}
+
+// SymbolStats are statistics of a symbol's returns that tell trending names from mean reverting ones
+type SymbolStats struct {
+	Hurst               float64 // above 0.5 trends, below 0.5 mean reverts
+	RealizedVolatility  float64 // annualized, from closes
+	ParkinsonVolatility float64 // annualized, from highs and lows
+	GarmanKlass         float64 // annualized, from opens, highs, lows and closes
+	Autocorrelation     float64 // of returns with the previous bar's returns
+	Skew                float64
+	Kurtosis            float64 // excess over a normal distribution
+}
+
+// PerformanceMetrics measure how a simulated portfolio did, returns and ratios are fractions (0.1 is 10%)
+type PerformanceMetrics struct {
+	TotalReturn         float64
+	CAGR                float64 // total return compounded per year
+	Volatility          float64 // annualized standard deviation of per bar returns
+	Sharpe              float64 // annualized, with no risk free rate
+	Sortino             float64 // annualized, with no risk free rate
+	Calmar              float64 // CAGR over max drawdown
+	MaxDrawdown         float64 // largest fall from a peak in portfolio value
+	MaxDrawdownDuration int     // most bars spent below a previous peak
+	Trades              int     // round trips closed
+	WinRate             float64 // fraction of round trips closed at a profit
+	ProfitFactor        float64 // gross profit over gross loss of round trips
+	AverageHolding      float64 // bars each round trip was held on average
+	Exposure            float64 // average fraction of the portfolio invested
+	Benchmark           BenchmarkMetrics
+}
+
+// BenchmarkMetrics compare a simulated portfolio with buying and holding a benchmark over the same bars
+type BenchmarkMetrics struct {
+	TotalReturn      float64 // of the benchmark
+	ExcessReturn     float64 // total return of the portfolio minus that of the benchmark
+	TrackingError    float64 // annualized standard deviation of the per bar difference in returns
+	InformationRatio float64 // annualized mean difference in returns over the tracking error
+	Beta             float64
+	Alpha            float64 // annualized return not explained by beta
+}
+
+// Levels are the support and resistance levels found for a symbol
+type Levels struct {
+	Pivot              float32
+	R1                 float32
+	R2                 float32
+	S1                 float32
+	S2                 float32
+	SwingHighs         []float32
+	SwingLows          []float32
+	Clusters           []PriceLevel
+	Support            float32 // nearest level below the current price (0 if none)
+	Resistance         float32 // nearest level above the current price (0 if none)
+	SupportDistance    float32 // percent the price is above support
+	ResistanceDistance float32 // percent the price is below resistance
+}
+
+// PriceLevel is a price that the symbol has turned at more than once
+type PriceLevel struct {
+	Price   float32
+	Touches int
 }
 
 type IntervalFormat int
@@ -79,6 +142,364 @@ type SimulationOptions struct {
 	MinCashLimit      float32
 	Iterations        int
 	ShowWorkLists     bool
+	MinBreadth        float32 // go flat and stop buying when fewer than this percent of symbols are on a buy signal (0 disables)
+	Regime            RegimeOptions
+	RegimeParams      map[Regime]RegimeParams // parameters to trade with instead of the ones above while in a given regime
+	StopAtSupport     bool                    // sell a holding once it closes below the support found when it was bought
+	TargetResistance  bool                    // sell a holding once it reaches the resistance found when it was bought
+	Commission        CommissionOptions
+	Slippage          SlippageOptions
+	FillTiming        FillTiming
+	CheckLookAhead    bool   // make sure the strategy only looks at past bars before simulating
+	EquityFile        string // save each run's equity curve to EquityFile-<run>.csv, .json and .png files when set
+	TradesFile        string // save each run's round trips to TradesFile-<run>.csv and .json and the stats of each symbol to TradesFile-<run>-symbols.csv and .json when set
+	Benchmark         string // symbol bought and held to compare against, an equal weight index of every symbol if missing
+	Quiet             bool   // only return the results without printing them
+	Seed              int64  // seeds the random choices of the simulation so it can be repeated, 0 picks one from the clock
+	Sizing            SizingOptions
+	Exits             ExitOptions
+	Shorts            ShortOptions
+	Lots              Lots         // whole shares of everything by default
+	Orders            OrderOptions // market orders by default
+	Workers           int          // repetitions run at once, one per cpu when 0
+	FastPeriod        int          // macd periods the data was analyzed with, to analyze it the same way when checking for look ahead (the defaults when 0)
+	SlowPeriod        int
+	SignalPeriod      int
+	WarmUp            int // bars each repetition needs before its first one, for strategies that look back over a window
+}
+
+// ShortOptions configures short selling in the simulator, the strategy shorts symbols on their macd sell
+// crossovers when it is enabled
+type ShortOptions struct {
+	Enabled           bool
+	BorrowRate        float32  // yearly percent of the value of the short positions paid to borrow the shares
+	InitialMargin     float32  // percent of the value of the short positions the equity must cover to short more (50 when 0)
+	MaintenanceMargin float32  // percent of the value of the short positions the equity must stay above or they are covered (30 when 0)
+	UptickRule        bool     // short sales only fill above the previous close of their symbol
+	HardToBorrow      []string // symbols that cannot be shorted
+}
+
+// ExitOptions configures the protective exits taken on each position, checked on the close of each of its
+// bars. Any of them left at 0 is not used.
+type ExitOptions struct {
+	StopPercent     float32 // sell once the price falls this percent below the entry
+	ATRStop         float32 // sell once the price falls this many average true ranges (at entry) below the entry
+	ATRPeriod       int     // bars of the average true range (14 when 0)
+	TrailingPercent float32 // sell once the price falls this percent below its highest close since the entry
+	TargetPercent   float32 // sell once the price rises this percent above the entry
+	MaxBars         int     // sell after holding for this many bars
+}
+
+// SizingMethod picks how much of the portfolio goes into each new position
+type SizingMethod int
+
+const (
+	AllIn            SizingMethod = iota // all the cash into the single best symbol
+	FixedFraction                        // a fixed fraction of the portfolio value
+	EqualWeight                          // the portfolio value split evenly between a number of slots
+	VolatilityTarget                     // enough shares that an average true range move is a fixed fraction of the portfolio
+	Kelly                                // a fraction of the kelly criterion from the strategy's own closed trades
+)
+
+// SizingOptions configures how positions are sized and how many can be held at once
+type SizingOptions struct {
+	Method             SizingMethod
+	Fraction           float32 // of the portfolio per position with fixed fraction, and with kelly until there are enough trades
+	Slots              int     // equal weight positions the portfolio is split into (MaxPositions when 0)
+	TargetRisk         float32 // fraction of the portfolio an average true range move of a position should be worth
+	ATRPeriod          int     // bars of the average true range (14 when 0)
+	KellyFraction      float32 // part of the full kelly bet to make, 0.5 is half kelly (and the default when 0)
+	MaxPositions       int     // positions held at once, 0 for no limit
+	MaxPositionPercent float32 // largest position as a percent of the portfolio value, 0 for no cap
+}
+
+// ParameterRange is the values Start, Start + Step, ... up to and including End
+type ParameterRange struct {
+	Start float32
+	End   float32
+	Step  float32
+}
+
+// Values lists the values of the range, just fallback for a range that was never set and just Start
+// without a positive Step
+func (r ParameterRange) Values(fallback float32) []float32 {
+	if r == (ParameterRange{}) {
+		return []float32{fallback}
+	}
+	if r.Step <= 0 {
+		return []float32{r.Start}
+	}
+	values := make([]float32, 0)
+	for i := 0; ; i++ {
+		v := r.Start + float32(i)*r.Step
+		if v > r.End+r.Step/1000 {
+			break
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// SweepOptions configures a search over every combination of the parameter ranges, each simulated with
+// the rest of Simulation
+type SweepOptions struct {
+	Simulation    SimulationOptions
+	MinBuySignal  ParameterRange
+	MaxSharePrice ParameterRange
+	MinCashLimit  ParameterRange
+	FastPeriod    ParameterRange // macd periods, the data is analyzed again for each of them
+	SlowPeriod    ParameterRange
+	SignalPeriod  ParameterRange
+	Metric        string // name of the metric results are ranked by (higher is better, except for drawdowns)
+	Workers       int    // simulations run at once
+	Top           int    // how many of the best results to print (0 for all)
+	HeatmapFile   string // plot the metric over the HeatmapX and HeatmapY parameters to this png when set
+	HeatmapX      string
+	HeatmapY      string
+}
+
+// WalkForwardOptions configures a walk forward optimization: the sweep picks the best parameters on each
+// in sample window and they are traded on the out of sample window right after it
+type WalkForwardOptions struct {
+	Sweep       SweepOptions // Sweep.Simulation.StartingCash is traded, its Iterations and NumberOfIntervals are not used
+	InSample    int          // bars the parameters are optimized on
+	OutOfSample int          // bars they are then traded on before rolling forward
+}
+
+// MonteCarloOptions configures a monte carlo analysis of the last Simulation.NumberOfIntervals bars
+type MonteCarloOptions struct {
+	Simulation    SimulationOptions
+	Paths         int     // resampled and perturbed runs each
+	EntryDelay    int     // buys of perturbed runs wait up to this many extra bars
+	PriceNoise    float32 // standard deviation of the noise added to fill prices of perturbed runs, as a fraction
+	RuinLevel     float32 // fraction of the starting cash a run must fall to to count as ruined
+	HistogramFile string  // plot the final equity of every path to HistogramFile-bootstrap.png and -perturbed.png when set
+}
+
+// SweepParameters are the values of one combination of a sweep
+type SweepParameters struct {
+	MinBuySignal  float32
+	MaxSharePrice float32
+	MinCashLimit  float32
+	FastPeriod    int
+	SlowPeriod    int
+	SignalPeriod  int
+}
+
+// LotSize is how finely a symbol can be traded, in quantity and in price
+type LotSize struct {
+	Increment decimal.Decimal // every quantity is a multiple of this, whole shares when 0
+	Minimum   decimal.Decimal // smallest quantity an order can be for
+	Tick      decimal.Decimal // every price is a multiple of this, a cent (a hundredth of a cent below $1) when 0
+}
+
+// Lot sizes of the usual kinds of assets
+var (
+	WholeShares      = LotSize{Increment: decimal.One, Minimum: decimal.One}
+	FractionalShares = LotSize{Increment: decimal.NewFromFloat(0.000001), Minimum: decimal.NewFromFloat(0.000001)}
+	CryptoLots       = LotSize{Increment: decimal.Smallest, Minimum: decimal.Smallest}
+)
+
+// Step is the increment quantities are multiples of
+func (l LotSize) Step() decimal.Decimal {
+	if l.Increment <= 0 {
+		return decimal.One
+	}
+	return l.Increment
+}
+
+// Quantity rounds a quantity down to one that can be traded, 0 if that is below the minimum
+func (l LotSize) Quantity(q decimal.Decimal) decimal.Decimal {
+	q = q.Floor(l.Step())
+	if q < l.Minimum || q < 0 {
+		return 0
+	}
+	return q
+}
+
+// TickFor is the tick prices around price are multiples of
+func (l LotSize) TickFor(price decimal.Decimal) decimal.Decimal {
+	if l.Tick > 0 {
+		return l.Tick
+	}
+	if price < decimal.One {
+		return decimal.NewFromFloat(0.0001)
+	}
+	return decimal.NewFromFloat(0.01)
+}
+
+// RoundPrice rounds a price to the nearest tick
+func (l LotSize) RoundPrice(price decimal.Decimal) decimal.Decimal {
+	return price.Round(l.TickFor(price))
+}
+
+// Price turns a price from the market data into one at a tick
+func (l LotSize) Price(price float32) decimal.Decimal {
+	return l.RoundPrice(decimal.NewFromFloat32(price))
+}
+
+// Lots is the lot size of each symbol, Default for the ones not listed
+type Lots struct {
+	Default LotSize
+	Symbols map[string]LotSize
+}
+
+// For returns the lot size of a symbol
+func (l Lots) For(symbol string) LotSize {
+	if lot, ok := l.Symbols[symbol]; ok {
+		return lot
+	}
+	return l.Default
+}
+
+// Whole tells whether every symbol is traded in whole shares
+func (l Lots) Whole() bool {
+	if !l.Default.Step().IsWhole() {
+		return false
+	}
+	for _, lot := range l.Symbols {
+		if !lot.Step().IsWhole() {
+			return false
+		}
+	}
+	return true
+}
+
+// FillTiming picks when an order placed on a bar's close is filled in a backtest
+type FillTiming int
+
+const (
+	NextOpen  FillTiming = iota // at the open of the symbol's next bar
+	NextClose                   // at the close of the symbol's next bar
+	SameClose                   // at the close the decision was made on, as if we could trade on it as it happens
+)
+
+// OrderType picks how an order is priced
+type OrderType int
+
+const (
+	MarketOrder    OrderType = iota // fills at whatever price the bar it reaches the market on gives
+	LimitOrder                      // fills at its limit price or better
+	StopOrder                       // becomes a market order once the price reaches its stop
+	StopLimitOrder                  // becomes a limit order once the price reaches its stop
+)
+
+// FillAssumption picks when a bar that reaches the limit price of an order is taken to fill it
+type FillAssumption int
+
+const (
+	TouchFill   FillAssumption = iota // as soon as the bar's high or low reaches the limit
+	ThroughFill                       // only once the bar trades past the limit, as orders waiting at the limit may not be reached
+)
+
+// OrderOptions configures the kind of orders a strategy places in a backtest and how they fill against
+// the high and low of the bars after them
+type OrderOptions struct {
+	Type        OrderType
+	LimitOffset float32 // percent the limit is placed above the price for buys and below it for sells, from the stop of stop limit orders (negative waits for a better price)
+	StopOffset  float32 // percent the stop is placed above the price for buys and below it for sells
+	Expiry      int     // bars of its symbol an order waits to fill before it is cancelled (1 when 0)
+	Fill        FillAssumption
+}
+
+// CommissionType picks how fees are charged on each fill
+type CommissionType int
+
+const (
+	NoCommission CommissionType = iota
+	PerShareCommission
+	PerTradeCommission
+	PercentCommission
+	MakerTakerCommission // crypto exchanges charge less for resting (maker) orders than for ones that take liquidity
+)
+
+// CommissionOptions configures the fees charged on each fill
+type CommissionOptions struct {
+	Type         CommissionType
+	Rate         float32 // dollars per share, dollars per trade or percent of the trade value depending on Type
+	MakerPercent float32 // percent of the trade value charged to maker fills
+	TakerPercent float32 // percent of the trade value charged to taker fills
+	Minimum      float32 // smallest fee charged on a fill
+	Maximum      float32 // largest fee charged on a fill (0 for no maximum)
+}
+
+// SlippageType picks how fill prices move against us
+type SlippageType int
+
+const (
+	NoSlippage SlippageType = iota
+	FixedSlippage
+	SpreadSlippage
+	VolumeSlippage
+)
+
+// SlippageOptions configures how much worse than expected fills are
+type SlippageOptions struct {
+	Type             SlippageType
+	BasisPoints      float32 // fixed slippage, or the spread / full participation impact depending on Type
+	SpreadFraction   float32 // fraction of a bar's high - low range taken as its spread (spread slippage)
+	MaxParticipation float32 // largest fraction of a bar's volume we can trade (volume slippage, 0 for no limit)
+}
+
+// Regime labels the state of the market on a given bar
+type Regime int
+
+const (
+	Sideways Regime = iota
+	Bull
+	Bear
+)
+
+// Regimes lists every regime in the order we report them
+var Regimes = []Regime{Bull, Bear, Sideways}
+
+func (r Regime) String() string {
+	switch r {
+	case Bull:
+		return "bull"
+	case Bear:
+		return "bear"
+	}
+	return "sideways"
+}
+
+// RegimeOptions configures how the market regime is classified
+type RegimeOptions struct {
+	Benchmark        string // symbol that represents the market, an equal weight index of all symbols is used if not found
+	TrendLength      int    // length of the moving average the benchmark trend is measured against
+	VolatilityLength int    // number of bars of returns used to measure volatility
+}
+
+// RegimeParams are the trading parameters that can be switched per regime
+type RegimeParams struct {
+	MinBuySignal  float32
+	MaxSharePrice float32
+	MinCashLimit  float32
+	NoBuying      bool
+}
+
+// Pair is two symbols whose log prices were found to be cointegrated
+type Pair struct {
+	First       string
+	Second      string
+	HedgeRatio  float64 // change in First's log price per unit change in Second's
+	Intercept   float64
+	ADF         float64 // engle-granger test statistic, more negative is more strongly cointegrated
+	HalfLife    float64 // bars for a spread to revert half way to its mean
+	Correlation float64
+}
+
+// PairsOptions configures the cointegration scan and the pairs trading simulation
+type PairsOptions struct {
+	FormationIntervals int     // bars used to find pairs and their hedge ratios before trading them
+	CriticalValue      float64 // adf statistic a pair must be below, 0 uses the 5% level
+	MaxHalfLife        float64
+	MaxPairs           int               // trade at most this many of the best pairs
+	ZLookback          int               // bars the spread z-score is measured over
+	EntryZ             float64           // open a spread position beyond this z-score
+	ExitZ              float64           // close it once back within this z-score
+	StopZ              float64           // or once it has moved beyond this z-score against us
+	Simulation         SimulationOptions // bars to trade, repetitions, cash, fills, costs, lots and short rules
 }
 
 // AnalysisOptions is the object that configures the analysis step where we concurrently analyze many symbols using a 3rd party (Alpaca)
@@ -99,4 +520,34 @@ type DayTraderOptions struct {
 	MaxSharePrice float32
 	MinBuySignal  float32
 	MinCashLimit  float32
+	MinBreadth    float32
+	Sizing        SizingOptions
+	Exits         ExitOptions
+	Lots          Lots // whole shares by default, and only whole shares can be traded on robinhood
+}
+
+// ReplayOptions configures a replay of the day trader over a past day of minute bars
+type ReplayOptions struct {
+	DayTrader    DayTraderOptions
+	Day          time.Time // any time on the day to replay, in the time zone of its session
+	StartingCash float32
+	Commission   CommissionOptions
+	Fill         FillAssumption // when a bar reaching the limit of an order fills it
+	ShowWorkList bool
+	Quiet        bool // only return the result without printing the routine's output
+}
+
+// Breadth summarizes how the whole analyzed universe behaved on a single bar
+type Breadth struct {
+	Time           int64
+	Total          int
+	BuySignals     int
+	PercentBuy     float32
+	Advances       int
+	Declines       int
+	Unchanged      int
+	AdvanceDecline int // cumulative advances minus declines
+	NewHighs       int
+	NewLows        int
+	McClellan      float32
 }
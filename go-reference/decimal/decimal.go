@@ -0,0 +1,252 @@
+// Package decimal holds a fixed point decimal number for amounts that have to add up exactly, like money and
+// fractional shares or crypto coins
+package decimal
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Places is how many digits after the decimal point a Decimal keeps, enough for a single satoshi
+const Places = 8
+
+// unit is the integer value of One
+const unit = 100000000
+
+// Decimal is a number with Places digits after the decimal point. Decimals are added, subtracted and compared
+// with the usual operators, the zero value is 0. Results out of the range of a Decimal, about 92 billion
+// either side of 0, saturate at Max or Min when made or multiplied and divided, sums are left to stay in it.
+type Decimal int64
+
+const (
+	Zero     Decimal = 0
+	One      Decimal = unit
+	Smallest Decimal = 1 // the smallest positive Decimal
+	Max      Decimal = math.MaxInt64
+	Min      Decimal = -Max
+)
+
+// New returns the whole number n
+func New(n int64) Decimal {
+	switch {
+	case n > Max.Int():
+		return Max
+	case n < Min.Int():
+		return Min
+	}
+	return Decimal(n * unit)
+}
+
+// NewFromFloat returns f rounded to the nearest Decimal
+func NewFromFloat(f float64) Decimal {
+	f = math.Round(f * unit)
+	switch {
+	case f >= math.MaxInt64:
+		return Max
+	case f <= -math.MaxInt64:
+		return Min
+	}
+	return Decimal(f)
+}
+
+// NewFromFloat32 returns f rounded to the nearest Decimal
+func NewFromFloat32(f float32) Decimal {
+	return NewFromFloat(float64(f))
+}
+
+// Parse reads a decimal number like "-12.5", with at most Places digits after the point
+func Parse(s string) (Decimal, error) {
+	text := s
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "-"), "+")
+	whole, fraction := text, ""
+	if point := strings.IndexByte(text, '.'); point >= 0 {
+		whole, fraction = text[:point], text[point+1:]
+	}
+	if len(fraction) > Places {
+		return 0, errors.New("too many decimal places in " + s)
+	}
+	if whole == "" && fraction == "" {
+		return 0, errors.New("no digits in " + s)
+	}
+	digits := whole + fraction + strings.Repeat("0", Places-len(fraction))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, errors.New("not a decimal number: " + s)
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, errors.New("decimal number out of range: " + s)
+	}
+	if negative {
+		n = -n
+	}
+	return Decimal(n), nil
+}
+
+// Mul returns d * e rounded half away from zero to the nearest Decimal
+func (d Decimal) Mul(e Decimal) Decimal {
+	negative := (d < 0) != (e < 0)
+	hi, lo := bits.Mul64(d.magnitude(), e.magnitude())
+	if hi >= unit {
+		// the quotient would not even fit in 64 bits
+		return signed(math.MaxUint64, false, negative)
+	}
+	q, r := bits.Div64(hi, lo, unit)
+	return signed(q, r >= unit/2, negative)
+}
+
+// Div returns d / e rounded half away from zero to the nearest Decimal, it panics when e is 0
+func (d Decimal) Div(e Decimal) Decimal {
+	negative := (d < 0) != (e < 0)
+	divisor := e.magnitude()
+	hi, lo := bits.Mul64(d.magnitude(), unit)
+	if divisor != 0 && hi >= divisor {
+		return signed(math.MaxUint64, false, negative)
+	}
+	q, r := bits.Div64(hi, lo, divisor)
+	return signed(q, r >= divisor-r, negative)
+}
+
+// Floor rounds d down to a multiple of step, d itself when step is not positive
+func (d Decimal) Floor(step Decimal) Decimal {
+	if step <= 0 {
+		return d
+	}
+	m := d % step
+	if m < 0 {
+		m = m + step
+	}
+	return d - m
+}
+
+// Ceil rounds d up to a multiple of step, d itself when step is not positive
+func (d Decimal) Ceil(step Decimal) Decimal {
+	floor := d.Floor(step)
+	if floor == d {
+		return d
+	}
+	return floor + step
+}
+
+// Round rounds d to the nearest multiple of step, halves away from zero
+func (d Decimal) Round(step Decimal) Decimal {
+	floor := d.Floor(step)
+	if floor == d {
+		return d
+	}
+	ceil := floor + step
+	if d-floor < ceil-d || (d-floor == ceil-d && d < 0) {
+		return floor
+	}
+	return ceil
+}
+
+// Abs returns the absolute value of d
+func (d Decimal) Abs() Decimal {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// IsWhole tells whether d has nothing after the decimal point
+func (d Decimal) IsWhole() bool {
+	return d%unit == 0
+}
+
+// Int returns the whole part of d
+func (d Decimal) Int() int64 {
+	return int64(d / unit)
+}
+
+// Float64 returns d as a float, which may not be exact
+func (d Decimal) Float64() float64 {
+	return float64(d) / unit
+}
+
+// Float32 returns d as a float, which may not be exact
+func (d Decimal) Float32() float32 {
+	return float32(d.Float64())
+}
+
+// String formats d without trailing zeros, like "12.5"
+func (d Decimal) String() string {
+	magnitude := d.magnitude()
+	s := strconv.FormatUint(magnitude/unit, 10)
+	if fraction := magnitude % unit; fraction != 0 {
+		digits := strconv.FormatUint(fraction, 10)
+		digits = strings.Repeat("0", Places-len(digits)) + digits
+		s = s + "." + strings.TrimRight(digits, "0")
+	}
+	if d < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// StringFixed formats d rounded to the given number of places after the decimal point, like "12.50" for 2
+func (d Decimal) StringFixed(places int) string {
+	if places < 0 {
+		places = 0
+	}
+	if places > Places {
+		places = Places
+	}
+	step := Smallest
+	for i := places; i < Places; i++ {
+		step = step * 10
+	}
+	rounded := d.Round(step)
+	magnitude := rounded.magnitude()
+	s := strconv.FormatUint(magnitude/unit, 10)
+	if places > 0 {
+		digits := strconv.FormatUint(magnitude%unit, 10)
+		digits = strings.Repeat("0", Places-len(digits)) + digits
+		s = s + "." + digits[:places]
+	}
+	if rounded < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// MarshalJSON writes d as a json number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads d from a json number or string
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	parsed, err := Parse(strings.Trim(string(b), `"`))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// magnitude is the absolute value of d in units of Smallest
+func (d Decimal) magnitude() uint64 {
+	if d < 0 {
+		return uint64(-d)
+	}
+	return uint64(d)
+}
+
+// signed turns a magnitude, rounded up by Smallest if up, back into a Decimal, saturating at Max and Min
+func signed(magnitude uint64, up bool, negative bool) Decimal {
+	if magnitude >= math.MaxInt64 {
+		magnitude = math.MaxInt64
+	} else if up {
+		magnitude++
+	}
+	if negative {
+		return -Decimal(magnitude)
+	}
+	return Decimal(magnitude)
+}
@@ -0,0 +1,156 @@
+package decimal
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMulSaturates(t *testing.T) {
+	cases := []struct {
+		d, e, want Decimal
+	}{
+		{New(3), NewFromFloat(1.5), NewFromFloat(4.5)},
+		{Max, One, Max},
+		{Max, New(2), Max},
+		{Max, New(-2), Min},
+		{Min, New(2), Min},
+		{Min, Min, Max},
+		{New(2000000), New(1000000), Max},
+		{New(-2000000), New(1000000), Min},
+	}
+	for _, c := range cases {
+		if got := c.d.Mul(c.e); got != c.want {
+			t.Errorf("%v * %v = %v, want %v", c.d, c.e, got, c.want)
+		}
+	}
+}
+
+func TestDivSaturates(t *testing.T) {
+	cases := []struct {
+		d, e, want Decimal
+	}{
+		{New(9), New(2), NewFromFloat(4.5)},
+		{Max, One, Max},
+		{Max, NewFromFloat(0.5), Max},
+		{Min, NewFromFloat(0.5), Min},
+		{Max, NewFromFloat(-0.5), Min},
+		{New(1000000000), Smallest, Max},
+		{One, Smallest, New(100000000)},
+	}
+	for _, c := range cases {
+		if got := c.d.Div(c.e); got != c.want {
+			t.Errorf("%v / %v = %v, want %v", c.d, c.e, got, c.want)
+		}
+	}
+}
+
+func TestDivByZeroPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("dividing by 0 did not panic")
+		}
+	}()
+	One.Div(Zero)
+}
+
+func TestNewSaturates(t *testing.T) {
+	cases := []struct {
+		got, want Decimal
+	}{
+		{New(math.MaxInt64), Max},
+		{New(math.MinInt64), Min},
+		{New(Max.Int()), Max.Floor(One)},
+		{NewFromFloat(1e12), Max},
+		{NewFromFloat(-1e12), Min},
+		{NewFromFloat(math.Inf(1)), Max},
+		{NewFromFloat(math.Inf(-1)), Min},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("got %v, want %v", c.got, c.want)
+		}
+	}
+}
+
+func TestRoundsToPlaces(t *testing.T) {
+	cases := []struct {
+		got  Decimal
+		want string
+	}{
+		{NewFromFloat(0.123456789), "0.12345679"},
+		{NewFromFloat(-0.123456789), "-0.12345679"},
+		{NewFromFloat(0.000000004), "0"},
+		{NewFromFloat(0.000000005), "0.00000001"},
+		{Smallest.Mul(NewFromFloat(0.5)), "0.00000001"},
+		{Smallest.Mul(NewFromFloat(-0.5)), "-0.00000001"},
+		{Smallest.Mul(NewFromFloat(0.49)), "0"},
+		{One.Div(New(3)), "0.33333333"},
+		{New(2).Div(New(3)), "0.66666667"},
+		{New(-2).Div(New(3)), "-0.66666667"},
+	}
+	for _, c := range cases {
+		if got := c.got.String(); got != c.want {
+			t.Errorf("got %v, want %v", got, c.want)
+		}
+	}
+}
+
+func TestParseFormatRoundTrip(t *testing.T) {
+	for _, s := range []string{"0", "1", "-1", "12.5", "-0.00000001", "0.1", "1234567.89", "92233720368.54775807", "-92233720368.54775807"} {
+		d, err := Parse(s)
+		if err != nil {
+			t.Errorf("could not parse %v: %v", s, err)
+			continue
+		}
+		if got := d.String(); got != s {
+			t.Errorf("parsed %v back to %v", s, got)
+		}
+		again, err := Parse(d.String())
+		if err != nil || again != d {
+			t.Errorf("%v did not survive formatting and parsing again, got %v (%v)", d, again, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{"", "-", ".", "abc", "1e5", "1.123456789", "92233720368.54775808", "1.2.3"} {
+		if d, err := Parse(s); err == nil {
+			t.Errorf("parsed %q as %v", s, d)
+		}
+	}
+}
+
+func TestStringFixed(t *testing.T) {
+	cases := []struct {
+		d      Decimal
+		places int
+		want   string
+	}{
+		{NewFromFloat(12.5), 2, "12.50"},
+		{NewFromFloat(12.345), 2, "12.35"},
+		{NewFromFloat(-12.345), 2, "-12.35"},
+		{NewFromFloat(0.004), 2, "0.00"},
+		{NewFromFloat(2.5), 0, "3"},
+		{Smallest, 8, "0.00000001"},
+	}
+	for _, c := range cases {
+		if got := c.d.StringFixed(c.places); got != c.want {
+			t.Errorf("%v to %v places = %v, want %v", c.d, c.places, got, c.want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	d := NewFromFloat(-1234.56789)
+	b, err := d.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Decimal
+	if err := back.UnmarshalJSON(b); err != nil || back != d {
+		t.Fatalf("%v came back from %s as %v (%v)", d, b, back, err)
+	}
+	if err := back.UnmarshalJSON([]byte(`"0.5"`)); err != nil || back != NewFromFloat(0.5) {
+		t.Fatalf("a quoted 0.5 came back as %v (%v)", back, err)
+	}
+}
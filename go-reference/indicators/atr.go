@@ -0,0 +1,34 @@
+package indicators
+
+import "github.com/mcmohorn/market/server/data"
+
+// DefaultATRPeriod is the usual number of bars the average true range is smoothed over
+const DefaultATRPeriod = 14
+
+// ATR is the average true range of the last bar, smoothed over period bars with wilder's moving average.
+// It is 0 when there are not enough bars.
+func ATR(bars []data.MyBar, period int) float32 {
+	if period <= 0 || len(bars) <= period {
+		return 0
+	}
+	atr := float32(0)
+	for i := 1; i < len(bars); i++ {
+		high := barHigh(bars[i])
+		low := barLow(bars[i])
+		previous := bars[i-1].Price
+		tr := high - low
+		if d := high - previous; d > tr {
+			tr = d
+		}
+		if d := previous - low; d > tr {
+			tr = d
+		}
+
+		if i <= period {
+			atr += tr / float32(period)
+		} else {
+			atr = (atr*float32(period-1) + tr) / float32(period)
+		}
+	}
+	return atr
+}
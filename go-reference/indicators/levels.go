@@ -0,0 +1,145 @@
+package indicators
+
+import (
+	"math"
+	"sort"
+
+	"github.com/mcmohorn/market/server/data"
+)
+
+const (
+	levelLookback    = 120   // how many bars back we look for swing highs and lows
+	swingStrength    = 3     // a swing high (low) is the highest (lowest) bar this many bars either side
+	clusterTolerance = 0.015 // swing points within this fraction of each other are one price level
+)
+
+// CalculateLevels finds pivot points, swing highs / lows and clustered support and resistance levels
+// using only the given bars, and reports the nearest levels around the last bar's price
+func CalculateLevels(bars []data.MyBar) data.Levels {
+	levels := data.Levels{
+		SwingHighs: make([]float32, 0),
+		SwingLows:  make([]float32, 0),
+		Clusters:   make([]data.PriceLevel, 0),
+	}
+	if len(bars) < 2 {
+		return levels
+	}
+
+	// classic floor pivots from the previous completed bar
+	prev := bars[len(bars)-2]
+	high, low, closePrice := barHigh(prev), barLow(prev), prev.Price
+	levels.Pivot = (high + low + closePrice) / 3
+	levels.R1 = 2*levels.Pivot - low
+	levels.S1 = 2*levels.Pivot - high
+	levels.R2 = levels.Pivot + (high - low)
+	levels.S2 = levels.Pivot - (high - low)
+
+	// swing points need swingStrength bars on both sides so the last few bars can not be one yet
+	start := len(bars) - levelLookback
+	if start < swingStrength {
+		start = swingStrength
+	}
+	points := make([]float32, 0)
+	for i := start; i < len(bars)-swingStrength; i++ {
+		isHigh, isLow := true, true
+		for j := i - swingStrength; j <= i+swingStrength; j++ {
+			if j == i {
+				continue
+			}
+			if barHigh(bars[j]) >= barHigh(bars[i]) {
+				isHigh = false
+			}
+			if barLow(bars[j]) <= barLow(bars[i]) {
+				isLow = false
+			}
+		}
+		if isHigh {
+			levels.SwingHighs = append(levels.SwingHighs, barHigh(bars[i]))
+			points = append(points, barHigh(bars[i]))
+		}
+		if isLow {
+			levels.SwingLows = append(levels.SwingLows, barLow(bars[i]))
+			points = append(points, barLow(bars[i]))
+		}
+	}
+	levels.Clusters = clusterLevels(points)
+
+	// nearest support below and resistance above the current price
+	price := bars[len(bars)-1].Price
+	candidates := []float32{levels.Pivot, levels.R1, levels.R2, levels.S1, levels.S2}
+	for _, c := range levels.Clusters {
+		candidates = append(candidates, c.Price)
+	}
+	for _, c := range candidates {
+		if c <= 0 {
+			continue
+		}
+		if c < price && (levels.Support == 0 || c > levels.Support) {
+			levels.Support = c
+		}
+		if c > price && (levels.Resistance == 0 || c < levels.Resistance) {
+			levels.Resistance = c
+		}
+	}
+	if price > 0 {
+		if levels.Support > 0 {
+			levels.SupportDistance = 100.0 * (price - levels.Support) / price
+		}
+		if levels.Resistance > 0 {
+			levels.ResistanceDistance = 100.0 * (levels.Resistance - price) / price
+		}
+	}
+
+	return levels
+}
+
+// clusterLevels groups nearby swing points into price levels, strongest (most touched) first
+func clusterLevels(points []float32) []data.PriceLevel {
+	clusters := make([]data.PriceLevel, 0)
+	if len(points) == 0 {
+		return clusters
+	}
+
+	sorted := make([]float32, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	sum := sorted[0]
+	n := 1
+	for i := 1; i <= len(sorted); i++ {
+		mean := sum / float32(n)
+		if i < len(sorted) && math.Abs(float64(sorted[i]-mean)) <= clusterTolerance*float64(mean) {
+			sum += sorted[i]
+			n++
+			continue
+		}
+		clusters = append(clusters, data.PriceLevel{Price: mean, Touches: n})
+		if i < len(sorted) {
+			sum = sorted[i]
+			n = 1
+		}
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].Touches > clusters[j].Touches
+	})
+	return clusters
+}
+
+// barHigh falls back to the price for bars without a high (our crypto bars only have closes)
+func barHigh(b data.MyBar) float32 {
+	if b.High > 0 {
+		return b.High
+	}
+	return b.Price
+}
+
+// barLow falls back to the price for bars without a low (our crypto bars only have closes)
+func barLow(b data.MyBar) float32 {
+	if b.Low > 0 {
+		return b.Low
+	}
+	return b.Price
+}
@@ -2,11 +2,23 @@ package indicators
 
 import "github.com/mcmohorn/market/server/data"
 
+// default macd periods
+const (
+	DefaultFastPeriod   = 12
+	DefaultSlowPeriod   = 26
+	DefaultSignalPeriod = 9
+)
+
 func CalculateMACD(bars []data.MyBar) []data.MyBar {
+	return CalculateMACDPeriods(bars, DefaultFastPeriod, DefaultSlowPeriod, DefaultSignalPeriod)
+}
+
+// CalculateMACDPeriods is CalculateMACD with the given ema periods
+func CalculateMACDPeriods(bars []data.MyBar, fast int, slow int, signal int) []data.MyBar {
 	// setting our ema and macd parameters
-	m1 := float32(12.0) // fast ema is ema1 (12)
-	m2 := float32(26.0) // slow ema is ema2 (26)
-	m3 := float32(9.0)  // length of ema for macdFast which gives us macdSlow
+	m1 := float32(fast)   // fast ema is ema1 (12)
+	m2 := float32(slow)   // slow ema is ema2 (26)
+	m3 := float32(signal) // length of ema for macdFast which gives us macdSlow
 	a1 := 2.0 / (m1 + 1.0)
 	a2 := 2.0 / (m2 + 1.0)
 	a3 := 2.0 / (m3 + 1.0)
@@ -29,7 +41,6 @@ func CalculateMACD(bars []data.MyBar) []data.MyBar {
 				Diff:             0,
 				DiffAdjusted:     0,
 				Price:            bar.Close,
-				NextPrice:        0,
 				T:                bar.Time,
 			})
 		} else {
@@ -54,13 +65,6 @@ func CalculateMACD(bars []data.MyBar) []data.MyBar {
 				T:                bar.Time,
 			}
 
-			// grab next price if available - it's useful in daily simulations to buy at opening price
-			if i < len(bars)-1 {
-				newbar.NextPrice = bars[i+1].Open
-			} else {
-				newbar.NextPrice = bars[i].Close
-			}
-
 			// decide on buy / sell indicators
 			if i > minDataPointsToBuy {
 				if newbar.MacdFast > newbar.MacdSlow {
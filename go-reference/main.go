@@ -2,10 +2,8 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"os"
 	"sync"
-	"time"
 
 	"github.com/alpacahq/alpaca-trade-api-go/alpaca"
 	"github.com/alpacahq/alpaca-trade-api-go/common"
@@ -22,12 +20,10 @@ func init() {
 
 func main() {
 	fmt.Println("Starting Matt's Market :)")
-	rand.Seed(time.Now().UnixNano()) // initialize random number generation
 	config := config.GetConfig()
 
 	// create an instance of the application that will do most of the work
 	app := &app.App{}
-	rand.Seed(time.Now().UnixNano())
 	var initWG sync.WaitGroup
 	initWG.Add(1)
 	app.Initialize(config, &initWG)
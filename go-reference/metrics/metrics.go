@@ -0,0 +1,275 @@
+// Package metrics measures the performance of backtests
+package metrics
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mcmohorn/market/server/analytics"
+	"github.com/mcmohorn/market/server/backtest"
+	"github.com/mcmohorn/market/server/data"
+)
+
+// Calculate measures a backtest result, annualizing with periodsPerYear bars a year
+func Calculate(result backtest.Result, periodsPerYear float64) data.PerformanceMetrics {
+	m := data.PerformanceMetrics{}
+	if len(result.Equity) == 0 || result.StartingCash <= 0 {
+		return m
+	}
+
+	startingCash := result.StartingCash.Float64()
+	returns := Returns(startingCash, result.Equity)
+	final := result.Equity[len(result.Equity)-1].Value.Float64()
+	m.TotalReturn = final/startingCash - 1
+	m.CAGR = CAGR(m.TotalReturn, float64(len(returns))/periodsPerYear)
+	m.Volatility = Volatility(returns, periodsPerYear)
+	m.Sharpe = Sharpe(returns, periodsPerYear)
+	m.Sortino = Sortino(returns, periodsPerYear)
+	m.MaxDrawdown, m.MaxDrawdownDuration = MaxDrawdown(result.Equity)
+	if m.MaxDrawdown > 0 {
+		m.Calmar = m.CAGR / m.MaxDrawdown
+	}
+	m.Exposure = Exposure(result.Equity)
+
+	m.Trades = len(result.Trades)
+	if m.Trades > 0 {
+		wins := 0
+		grossProfit := 0.0
+		grossLoss := 0.0
+		bars := 0
+		for _, t := range result.Trades {
+			if t.Profit > 0 {
+				wins++
+				grossProfit += t.Profit.Float64()
+			} else {
+				grossLoss -= t.Profit.Float64()
+			}
+			bars += t.Bars
+		}
+		m.WinRate = float64(wins) / float64(m.Trades)
+		m.AverageHolding = float64(bars) / float64(m.Trades)
+		if grossLoss > 0 {
+			m.ProfitFactor = grossProfit / grossLoss
+		} else if grossProfit > 0 {
+			m.ProfitFactor = math.Inf(1)
+		}
+	}
+	return m
+}
+
+// Score returns the named metric oriented so that higher is always better (risk measures are negated)
+func Score(m data.PerformanceMetrics, name string) (float64, error) {
+	switch name {
+	case "return":
+		return m.TotalReturn, nil
+	case "cagr":
+		return m.CAGR, nil
+	case "volatility":
+		return -m.Volatility, nil
+	case "sharpe":
+		return m.Sharpe, nil
+	case "sortino":
+		return m.Sortino, nil
+	case "calmar":
+		return m.Calmar, nil
+	case "drawdown":
+		return -m.MaxDrawdown, nil
+	case "winrate":
+		return m.WinRate, nil
+	case "profitfactor":
+		return m.ProfitFactor, nil
+	case "excess":
+		return m.Benchmark.ExcessReturn, nil
+	case "information":
+		return m.Benchmark.InformationRatio, nil
+	case "alpha":
+		return m.Benchmark.Alpha, nil
+	}
+	return 0, fmt.Errorf("unknown metric %v", name)
+}
+
+// Returns is the return of the portfolio over each bar, starting from the given value
+func Returns(start float64, equity []backtest.EquityPoint) []float64 {
+	returns := make([]float64, 0, len(equity))
+	previous := start
+	for _, p := range equity {
+		if previous > 0 {
+			returns = append(returns, p.Value.Float64()/previous-1)
+		} else {
+			returns = append(returns, 0)
+		}
+		previous = p.Value.Float64()
+	}
+	return returns
+}
+
+// PriceReturns is the return of holding something with the given prices over each bar, bought at the first one
+func PriceReturns(prices []float64) []float64 {
+	returns := make([]float64, len(prices))
+	for i := 1; i < len(prices); i++ {
+		if prices[i-1] > 0 {
+			returns[i] = prices[i]/prices[i-1] - 1
+		}
+	}
+	return returns
+}
+
+// Compare measures returns against the returns of a benchmark over the same bars
+func Compare(returns []float64, benchmark []float64, periodsPerYear float64) data.BenchmarkMetrics {
+	m := data.BenchmarkMetrics{}
+	if len(returns) != len(benchmark) || len(returns) < 2 {
+		return m
+	}
+
+	m.TotalReturn = compound(benchmark)
+	m.ExcessReturn = compound(returns) - m.TotalReturn
+
+	active := make([]float64, len(returns))
+	for i := range returns {
+		active[i] = returns[i] - benchmark[i]
+	}
+	activeMean, activeStd := analytics.MeanStd(active)
+	m.TrackingError = activeStd * math.Sqrt(periodsPerYear)
+	if activeStd > 0 {
+		m.InformationRatio = activeMean / activeStd * math.Sqrt(periodsPerYear)
+	}
+
+	mean, _ := analytics.MeanStd(returns)
+	benchmarkMean, benchmarkStd := analytics.MeanStd(benchmark)
+	if benchmarkStd > 0 {
+		covariance := 0.0
+		for i := range returns {
+			covariance += (returns[i] - mean) * (benchmark[i] - benchmarkMean)
+		}
+		covariance = covariance / float64(len(returns)-1)
+		m.Beta = covariance / (benchmarkStd * benchmarkStd)
+	}
+	m.Alpha = (mean - m.Beta*benchmarkMean) * periodsPerYear
+	return m
+}
+
+// compound is the total return of a series of returns
+func compound(returns []float64) float64 {
+	growth := 1.0
+	for _, r := range returns {
+		growth *= 1 + r
+	}
+	return growth - 1
+}
+
+// CAGR is the yearly return that compounds to the total return over the given number of years
+func CAGR(totalReturn float64, years float64) float64 {
+	if years <= 0 || totalReturn <= -1 {
+		return totalReturn
+	}
+	return math.Pow(1+totalReturn, 1/years) - 1
+}
+
+// Volatility is the annualized standard deviation of the returns
+func Volatility(returns []float64, periodsPerYear float64) float64 {
+	_, std := analytics.MeanStd(returns)
+	return std * math.Sqrt(periodsPerYear)
+}
+
+// Sharpe is the annualized mean return over its standard deviation
+func Sharpe(returns []float64, periodsPerYear float64) float64 {
+	mean, std := analytics.MeanStd(returns)
+	if std == 0 {
+		return 0
+	}
+	return mean / std * math.Sqrt(periodsPerYear)
+}
+
+// Sortino is the annualized mean return over its downside deviation, so only losses count as risk
+func Sortino(returns []float64, periodsPerYear float64) float64 {
+	if len(returns) == 0 {
+		return 0
+	}
+	mean, _ := analytics.MeanStd(returns)
+	downside := 0.0
+	for _, r := range returns {
+		if r < 0 {
+			downside += r * r
+		}
+	}
+	downside = math.Sqrt(downside / float64(len(returns)))
+	if downside == 0 {
+		return 0
+	}
+	return mean / downside * math.Sqrt(periodsPerYear)
+}
+
+// MaxDrawdown returns the largest fall in value from a peak as a fraction of the peak and the most bars
+// the portfolio spent below a previous peak
+func MaxDrawdown(equity []backtest.EquityPoint) (float64, int) {
+	maxDrawdown := 0.0
+	maxDuration := 0
+	duration := 0
+	for _, p := range equity {
+		if p.Drawdown <= 0 {
+			duration = 0
+			continue
+		}
+		duration++
+		if duration > maxDuration {
+			maxDuration = duration
+		}
+		maxDrawdown = math.Max(maxDrawdown, float64(p.Drawdown))
+	}
+	return maxDrawdown, maxDuration
+}
+
+// Exposure is the average fraction of the portfolio held in shares rather than cash
+func Exposure(equity []backtest.EquityPoint) float64 {
+	if len(equity) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, p := range equity {
+		total += float64(p.Exposure)
+	}
+	return total / float64(len(equity))
+}
+
+// Average is the mean of each metric over a number of backtests, the profit factor only counts backtests
+// that had losing trades
+func Average(all []data.PerformanceMetrics) data.PerformanceMetrics {
+	avg := data.PerformanceMetrics{}
+	if len(all) == 0 {
+		return avg
+	}
+	n := float64(len(all))
+	trades := 0
+	duration := 0
+	profitFactors := 0
+	for _, m := range all {
+		avg.TotalReturn += m.TotalReturn / n
+		avg.CAGR += m.CAGR / n
+		avg.Volatility += m.Volatility / n
+		avg.Sharpe += m.Sharpe / n
+		avg.Sortino += m.Sortino / n
+		avg.Calmar += m.Calmar / n
+		avg.MaxDrawdown += m.MaxDrawdown / n
+		avg.WinRate += m.WinRate / n
+		avg.AverageHolding += m.AverageHolding / n
+		avg.Exposure += m.Exposure / n
+		avg.Benchmark.TotalReturn += m.Benchmark.TotalReturn / n
+		avg.Benchmark.ExcessReturn += m.Benchmark.ExcessReturn / n
+		avg.Benchmark.TrackingError += m.Benchmark.TrackingError / n
+		avg.Benchmark.InformationRatio += m.Benchmark.InformationRatio / n
+		avg.Benchmark.Beta += m.Benchmark.Beta / n
+		avg.Benchmark.Alpha += m.Benchmark.Alpha / n
+		if m.Trades > 0 && !math.IsInf(m.ProfitFactor, 1) {
+			avg.ProfitFactor += m.ProfitFactor
+			profitFactors++
+		}
+		trades += m.Trades
+		duration += m.MaxDrawdownDuration
+	}
+	if profitFactors > 0 {
+		avg.ProfitFactor = avg.ProfitFactor / float64(profitFactors)
+	}
+	avg.Trades = trades / len(all)
+	avg.MaxDrawdownDuration = duration / len(all)
+	return avg
+}
@@ -0,0 +1,145 @@
+// Package montecarlo measures how robust backtest results are by resampling and perturbing them
+package montecarlo
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/mcmohorn/market/server/backtest"
+)
+
+// Distribution summarizes the values of many runs
+type Distribution struct {
+	Mean float64
+	P5   float64
+	P25  float64
+	P50  float64
+	P75  float64
+	P95  float64
+}
+
+// Result is the outcome of many resampled or perturbed runs
+type Result struct {
+	FinalEquity       Distribution
+	MaxDrawdown       Distribution
+	ProbabilityOfRuin float64   // fraction of runs that fell to the ruin level at some point
+	Finals            []float64 // final equity of each run
+	Drawdowns         []float64 // max drawdown of each run
+}
+
+// Path is the equity of one run after each step, starting after the first
+type Path []float64
+
+// TradeReturns is the return of each round trip on what was paid for it
+func TradeReturns(trades []backtest.Trade) []float64 {
+	returns := make([]float64, 0, len(trades))
+	for _, t := range trades {
+		cost := t.EntryPrice.Mul(t.Quantity).Float64()
+		if cost > 0 {
+			returns = append(returns, t.Profit.Float64()/cost)
+		}
+	}
+	return returns
+}
+
+// Bootstrap builds paths by drawing trade returns with replacement and compounding them one after another on
+// the whole of the equity, as a strategy that goes all in on every trade would. Each path has as many trades
+// as there are returns.
+func Bootstrap(returns []float64, startingCash float64, paths int, rng *rand.Rand) []Path {
+	all := make([]Path, paths)
+	for p := range all {
+		path := make(Path, len(returns))
+		value := startingCash
+		for i := range path {
+			value *= 1 + returns[rng.Intn(len(returns))]
+			path[i] = value
+		}
+		all[p] = path
+	}
+	return all
+}
+
+// EquityPath is the value after each bar of a backtest's equity curve
+func EquityPath(equity []backtest.EquityPoint) Path {
+	path := make(Path, len(equity))
+	for i, p := range equity {
+		path[i] = p.Value.Float64()
+	}
+	return path
+}
+
+// Summarize the final equity, max drawdown and ruin of paths that started with startingCash. A path is
+// ruined once it falls to ruinLevel times the starting cash.
+func Summarize(paths []Path, startingCash float64, ruinLevel float64) Result {
+	result := Result{
+		Finals:    make([]float64, 0, len(paths)),
+		Drawdowns: make([]float64, 0, len(paths)),
+	}
+	if len(paths) == 0 {
+		return result
+	}
+
+	ruined := 0
+	for _, path := range paths {
+		final := startingCash
+		peak := startingCash
+		maxDrawdown := 0.0
+		isRuined := false
+		for _, value := range path {
+			final = value
+			peak = math.Max(peak, value)
+			if peak > 0 {
+				maxDrawdown = math.Max(maxDrawdown, 1-value/peak)
+			}
+			if value <= ruinLevel*startingCash {
+				isRuined = true
+			}
+		}
+		if isRuined {
+			ruined++
+		}
+		result.Finals = append(result.Finals, final)
+		result.Drawdowns = append(result.Drawdowns, maxDrawdown)
+	}
+
+	result.FinalEquity = Distribute(result.Finals)
+	result.MaxDrawdown = Distribute(result.Drawdowns)
+	result.ProbabilityOfRuin = float64(ruined) / float64(len(paths))
+	return result
+}
+
+// Distribute works out the mean and percentiles of the values
+func Distribute(values []float64) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	mean := 0.0
+	for _, v := range sorted {
+		mean += v
+	}
+	return Distribution{
+		Mean: mean / float64(len(sorted)),
+		P5:   Percentile(sorted, 5),
+		P25:  Percentile(sorted, 25),
+		P50:  Percentile(sorted, 50),
+		P75:  Percentile(sorted, 75),
+		P95:  Percentile(sorted, 95),
+	}
+}
+
+// Percentile of sorted values, interpolating between the closest two
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if upper >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
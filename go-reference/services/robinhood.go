@@ -3,13 +3,13 @@ package services
 import (
 	"errors"
 	"fmt"
-	"math"
 	"os"
 	"sync"
 	"time"
 
 	"astuart.co/go-robinhood"
 	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
 	"github.com/mcmohorn/market/server/helper"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -63,7 +63,7 @@ func GetCryptoPositions(cli *robinhood.Client, wg *sync.WaitGroup) ([]data.MyPos
 	for _, p := range pairs {
 		mypositions = append(mypositions, data.MyPosition{
 			Symbol:        p.Symbol,
-			Quantity:      float32(p.CyrptoAssetCurrency.Increment),
+			Quantity:      decimal.NewFromFloat(p.CyrptoAssetCurrency.Increment),
 			AssetCurrency: p.CyrptoAssetCurrency,
 			CurrencyPair:  p,
 		})
@@ -100,7 +100,7 @@ func GetPositions(cli *robinhood.Client, wg *sync.WaitGroup, acc robinhood.Accou
 			qs, _ := cli.GetQuote(i.Symbol)
 			mypositions = append(mypositions, data.MyPosition{
 				Symbol:       i.Symbol,
-				Quantity:     float32(p.Quantity),
+				Quantity:     decimal.NewFromFloat(p.Quantity),
 				CurrentPrice: float32(qs[0].LastTradePrice),
 				Instrument:   i,
 			})
@@ -112,24 +112,30 @@ func GetPositions(cli *robinhood.Client, wg *sync.WaitGroup, acc robinhood.Accou
 
 }
 
-// TradeQuantityAtPrice calls robinhood trade api to submit an order to buy / sell
-func TradeQuantityAtPrice(cli *robinhood.Client, wg *sync.WaitGroup, DB *mongo.Database, symbol string, quant float32, price float64, side robinhood.OrderSide) (*robinhood.OrderOutput, error) {
+// TradeQuantityAtPrice calls robinhood trade api to submit an order to buy / sell, stock orders are for whole
+// shares only
+func TradeQuantityAtPrice(cli *robinhood.Client, wg *sync.WaitGroup, DB *mongo.Database, symbol string, quant decimal.Decimal, price decimal.Decimal, side robinhood.OrderSide) (*robinhood.OrderOutput, error) {
 
 	defer wg.Done()
 
+	if !quant.IsWhole() || quant <= 0 {
+		return nil, fmt.Errorf("cannot order %v shares of %v, stock orders are for a whole number of shares", quant, symbol)
+	}
+
 	i, _ := cli.GetInstrumentForSymbol(symbol)
 
-	fmt.Printf("Attempting to %v %v shares of %v at %.2f\n", side, uint64(quant), symbol, price)
+	price = data.WholeShares.RoundPrice(price)
+	fmt.Printf("Attempting to %v %v shares of %v at %v\n", side, quant, symbol, price.StringFixed(2))
 
 	orderOptions := robinhood.OrderOpts{
-		Price:    math.Round(price*100) / 100,
+		Price:    price.Float64(),
 		Side:     side,
-		Quantity: uint64(quant),
+		Quantity: uint64(quant.Int()),
 	}
 
 	newevent := data.OrderEvent{
 		Symbol:   symbol,
-		Quantity: quant,
+		Quantity: quant.Float32(),
 	}
 
 	orderOutput, err := cli.Order(i, orderOptions)
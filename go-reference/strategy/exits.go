@@ -0,0 +1,124 @@
+package strategy
+
+import (
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/indicators"
+)
+
+// Reasons a position is sold, recorded on the order
+const (
+	ExitSignal       = "signal"        // the buy signal went away
+	ExitBreadth      = "breadth"       // the market breadth turned weak
+	ExitSupport      = "support"       // closed below the support found at entry
+	ExitResistance   = "resistance"    // reached the resistance found at entry
+	ExitStop         = "stop"          // fixed percent stop loss
+	ExitATRStop      = "atr stop"      // average true range stop loss
+	ExitTrailingStop = "trailing stop" // moved too far back from its best close
+	ExitTarget       = "target"        // reached the profit target
+	ExitTime         = "time"          // held for too many bars
+	ExitEnd          = "end"           // the test ran out of bars, the position is valued at its last price
+	ExitMarginCall   = "margin call"   // the broker covered a short position
+	ExitLeg          = "leg"           // the other leg of a pair is not held
+)
+
+// Exits keeps track of each open position so its protective exits can be checked on every bar. Short
+// positions are stopped out as the price rises and reach their target as it falls.
+type Exits struct {
+	Options data.ExitOptions
+
+	positions map[string]*exitPosition
+}
+
+// exitPosition is what the exits of a single position are measured from
+type exitPosition struct {
+	short bool
+	entry float32
+	stop  float32 // average true range stop, 0 if not used
+	best  float32 // highest close since the entry, lowest when short
+	bars  int     // bars seen since the entry
+}
+
+// NewExits creates exits checked with the given options
+func NewExits(opts data.ExitOptions) *Exits {
+	return &Exits{
+		Options:   opts,
+		positions: make(map[string]*exitPosition),
+	}
+}
+
+// Open starts tracking a position bought (or sold short) on the last bar of history, adding to a position
+// keeps the original entry. It is tracked from when the order is placed, for as long as it waits to fill.
+func (e *Exits) Open(symbol string, history []data.MyBar, short bool) {
+	if _, ok := e.positions[symbol]; ok || len(history) == 0 {
+		return
+	}
+	price := history[len(history)-1].Price
+	position := &exitPosition{short: short, entry: price, best: price}
+	if e.Options.ATRStop > 0 {
+		period := e.Options.ATRPeriod
+		if period <= 0 {
+			period = indicators.DefaultATRPeriod
+		}
+		if atr := indicators.ATR(history, period); atr > 0 {
+			position.stop = price - e.Options.ATRStop*atr
+			if short {
+				position.stop = price + e.Options.ATRStop*atr
+			}
+		}
+	}
+	e.positions[symbol] = position
+}
+
+// Check looks at the last bar of history of a held position and returns why it should be sold, or "" to
+// keep holding it. A position we did not see bought, like one held before the strategy started, is
+// tracked from this bar on.
+func (e *Exits) Check(symbol string, history []data.MyBar, short bool) string {
+	position, ok := e.positions[symbol]
+	if !ok {
+		e.Open(symbol, history, short)
+		return ""
+	}
+	price := history[len(history)-1].Price
+	position.bars++
+
+	// measure everything as a gain in the direction of the position
+	gain := func(from float32, to float32) float32 {
+		if position.short {
+			return 1 - to/from
+		}
+		return to/from - 1
+	}
+	if gain(position.best, price) > 0 {
+		position.best = price
+	}
+
+	opts := e.Options
+	switch {
+	case opts.StopPercent > 0 && gain(position.entry, price) <= -opts.StopPercent/100:
+		return ExitStop
+	case position.stop > 0 && gain(position.stop, price) <= 0:
+		return ExitATRStop
+	case opts.TrailingPercent > 0 && gain(position.best, price) <= -opts.TrailingPercent/100:
+		return ExitTrailingStop
+	case opts.TargetPercent > 0 && gain(position.entry, price) >= opts.TargetPercent/100:
+		return ExitTarget
+	case opts.MaxBars > 0 && position.bars >= opts.MaxBars:
+		return ExitTime
+	}
+	return ""
+}
+
+// Close stops tracking a position once it is sold
+func (e *Exits) Close(symbol string) {
+	delete(e.positions, symbol)
+}
+
+// Keep stops tracking the positions no longer held, like buys that were never filled, keeping the ones
+// whose orders are still waiting to fill
+func (e *Exits) Keep(portfolio *Portfolio) {
+	for symbol := range e.positions {
+		if portfolio.Shares[symbol] == 0 && !portfolio.Pending[symbol] {
+			delete(e.positions, symbol)
+		}
+	}
+}
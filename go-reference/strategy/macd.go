@@ -0,0 +1,350 @@
+package strategy
+
+import (
+	"sort"
+
+	"github.com/mcmohorn/market/server/analyzer"
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/indicators"
+)
+
+// MACD buys the symbols with the strongest (price adjusted) macd buy signal, sized by its Sizer (all the
+// cash into the single best one by default), and sells a holding as soon as its buy signal goes away or one
+// of its Exits is hit. With Short it also sells short the symbols that just crossed to a sell signal,
+// covering them once the buy signal is back.
+type MACD struct {
+	MinBuySignal     float32
+	MaxSharePrice    float32
+	MinCashLimit     float32
+	MinBreadth       float32
+	RegimeParams     map[data.Regime]data.RegimeParams
+	StopAtSupport    bool
+	TargetResistance bool
+	Sizer            *Sizer
+	Exits            *Exits
+	Short            bool
+	Lots             data.Lots         // how finely each symbol can be bought, whole shares by default
+	Orders           data.OrderOptions // kind of orders placed, market orders by default
+
+	stops   map[string]float32 // support below each holding when it was bought
+	targets map[string]float32 // resistance above each holding when it was bought
+	entries map[string]float32 // price each holding was first bought at
+	closing map[string]float32 // return of each holding an order was placed to close, recorded once it is gone
+}
+
+// NewMACD creates the macd strategy configured for a simulation
+func NewMACD(opts *data.SimulationOptions) *MACD {
+	return &MACD{
+		MinBuySignal:     opts.MinBuySignal,
+		MaxSharePrice:    opts.MaxSharePrice,
+		MinCashLimit:     opts.MinCashLimit,
+		MinBreadth:       opts.MinBreadth,
+		RegimeParams:     opts.RegimeParams,
+		StopAtSupport:    opts.StopAtSupport,
+		TargetResistance: opts.TargetResistance,
+		Sizer:            &Sizer{Options: opts.Sizing},
+		Exits:            NewExits(opts.Exits),
+		Short:            opts.Shorts.Enabled,
+		Orders:           opts.Orders,
+		Lots:             opts.Lots,
+		stops:            make(map[string]float32),
+		targets:          make(map[string]float32),
+		entries:          make(map[string]float32),
+		closing:          make(map[string]float32),
+	}
+}
+
+// NewDayTraderMACD creates the macd strategy configured for live day trading
+func NewDayTraderMACD(opts *data.DayTraderOptions) *MACD {
+	return &MACD{
+		MinBuySignal:  opts.MinBuySignal,
+		MaxSharePrice: opts.MaxSharePrice,
+		MinCashLimit:  opts.MinCashLimit,
+		MinBreadth:    opts.MinBreadth,
+		Sizer:         &Sizer{Options: opts.Sizing},
+		Exits:         NewExits(opts.Exits),
+		Lots:          opts.Lots,
+		stops:         make(map[string]float32),
+		targets:       make(map[string]float32),
+		entries:       make(map[string]float32),
+		closing:       make(map[string]float32),
+	}
+}
+
+func (s *MACD) Name() string {
+	return "macd"
+}
+
+func (s *MACD) OnBar(market *MarketState, portfolio *Portfolio) []Order {
+	orders := make([]Order, 0)
+	weakBreadth := analyzer.IsBreadthWeak(market.Breadth, s.MinBreadth)
+
+	// trade with the parameters for the current regime if there are any
+	minBuySignal, maxSharePrice, minCashLimit, noBuying := s.MinBuySignal, s.MaxSharePrice, s.MinCashLimit, false
+	if params, ok := s.RegimeParams[market.Regime]; ok {
+		minBuySignal, maxSharePrice, minCashLimit, noBuying = params.MinBuySignal, params.MaxSharePrice, params.MinCashLimit, params.NoBuying
+	}
+
+	// check each of our holdings for sell signals
+	s.keep(portfolio)
+	s.Exits.Keep(portfolio)
+	held := 0
+	for symbol := range portfolio.Pending {
+		// buys and short sales still waiting to fill take up a position already
+		if portfolio.Shares[symbol] == 0 {
+			held++
+		}
+	}
+	for _, key := range portfolio.Symbols() {
+		num := portfolio.Shares[key]
+		held++
+		currIndex := market.Find(key)
+		if currIndex < 0 || !market.Has(currIndex) || portfolio.Pending[key] {
+			// nothing to decide on, or an order on it is still waiting to fill
+			continue
+		}
+
+		bar := market.Bar(currIndex)
+		short := num < 0
+		// protective exits come first, they are checked on every bar to keep their state up to date
+		reason := s.Exits.Check(key, market.History(currIndex), short)
+		switch {
+		case reason != "":
+			// already closing it
+		case short:
+			// a short is only covered once the buy signal is back
+			if bar.BuySignal {
+				reason = ExitSignal
+			}
+		case !bar.BuySignal:
+			reason = ExitSignal
+		case weakBreadth:
+			reason = ExitBreadth
+		case s.StopAtSupport && s.stops[key] > 0 && bar.Price < s.stops[key]:
+			reason = ExitSupport
+		case s.TargetResistance && s.targets[key] > 0 && bar.Price >= s.targets[key]:
+			reason = ExitResistance
+		}
+
+		if reason != "" {
+			// time to sell, or buy back what we are short
+			order := Order{
+				Symbol:   key,
+				Buy:      short,
+				Quantity: num,
+				Price:    s.Lots.For(key).Price(bar.Price),
+				Reason:   reason,
+			}
+			entry := s.entries[key]
+			if short {
+				order.Quantity = -num
+				if entry > 0 {
+					s.closing[key] = 1 - bar.Price/entry
+				}
+			} else if entry > 0 {
+				s.closing[key] = bar.Price/entry - 1
+			}
+			orders = append(orders, order.Priced(s.Orders, s.Lots.For(key)))
+			s.Exits.Close(key)
+			held--
+		}
+	}
+
+	// the proceeds of short sales are held against them, so they cannot be spent
+	cash := portfolio.Cash - market.ShortValue(portfolio)
+	equity := market.Value(portfolio)
+	cashLimit := decimal.NewFromFloat32(minCashLimit)
+	buying := cash > cashLimit && !weakBreadth && !noBuying
+
+	// buy the good stuff, best first, while we have cash and room for more positions
+	candidates := make([]int, 0)
+	if buying {
+		candidates = s.candidates(market, portfolio, minBuySignal, maxSharePrice, false)
+	}
+	if s.Sizer.Options.Method == data.AllIn {
+		// as much as we can of the best one, even if we already hold it, or short the best one when there is
+		// nothing to buy or hold
+		if len(candidates) > 0 {
+			return s.buy(orders, market, candidates[0], cash)
+		}
+		if s.Short && held == 0 {
+			if shorts := s.candidates(market, portfolio, minBuySignal, maxSharePrice, true); len(shorts) > 0 {
+				orders = s.sellShort(orders, market, shorts[0], equity)
+			}
+		}
+		return orders
+	}
+
+	maxPositions := s.Sizer.MaxPositions()
+	for _, j := range candidates {
+		if (maxPositions > 0 && held >= maxPositions) || cash <= cashLimit {
+			break
+		}
+		if portfolio.Shares[market.Symbol(j)] > 0 {
+			continue
+		}
+		price := market.Bar(j).Price
+		dollars := s.Sizer.Dollars(equity, market.History(j), price)
+		if dollars > cash {
+			dollars = cash
+		}
+		before := len(orders)
+		orders = s.buy(orders, market, j, dollars)
+		if len(orders) > before {
+			bought := orders[len(orders)-1]
+			cash = cash - bought.Quantity.Mul(bought.Price)
+			held++
+		}
+	}
+	if !s.Short {
+		return orders
+	}
+
+	// short the weakest while there is room for more positions, the margin they need is left to the broker
+	for _, j := range s.candidates(market, portfolio, minBuySignal, maxSharePrice, true) {
+		if maxPositions > 0 && held >= maxPositions {
+			break
+		}
+		if portfolio.Shares[market.Symbol(j)] < 0 {
+			continue
+		}
+		dollars := s.Sizer.Dollars(equity, market.History(j), market.Bar(j).Price)
+		before := len(orders)
+		orders = s.sellShort(orders, market, j, dollars)
+		if len(orders) > before {
+			held++
+		}
+	}
+
+	return orders
+}
+
+// keep forgets the positions no longer held, recording the return of the ones we closed, and the orders
+// that never filled, like buys that did not reach their limit or short sales that were turned down. Symbols
+// with orders still waiting to fill are kept until they fill or expire.
+func (s *MACD) keep(portfolio *Portfolio) {
+	gone := func(symbol string) bool {
+		return portfolio.Shares[symbol] == 0 && !portfolio.Pending[symbol]
+	}
+	for symbol, r := range s.closing {
+		if portfolio.Pending[symbol] {
+			continue
+		}
+		if portfolio.Shares[symbol] == 0 {
+			s.Sizer.Record(r)
+		}
+		delete(s.closing, symbol)
+	}
+	for symbol := range s.entries {
+		if gone(symbol) {
+			delete(s.entries, symbol)
+		}
+	}
+	for symbol := range s.stops {
+		if gone(symbol) {
+			delete(s.stops, symbol)
+			delete(s.targets, symbol)
+		}
+	}
+}
+
+// candidates are the symbols that meet our requirements, the strongest adjusted diff first, leaving out the
+// ones with orders still waiting to fill. To short they are the symbols that just crossed from a buy to a
+// sell signal.
+func (s *MACD) candidates(market *MarketState, portfolio *Portfolio, minBuySignal float32, maxSharePrice float32, short bool) []int {
+	candidates := make([]int, 0)
+	for j := 0; j < market.Len(); j++ {
+		if !market.Has(j) || portfolio.Pending[market.Symbol(j)] {
+			continue
+		}
+		bar := market.Bar(j)
+		if bar.Price >= maxSharePrice {
+			continue
+		}
+		if short {
+			history := market.History(j)
+			crossed := !bar.BuySignal && len(history) > 1 && history[len(history)-2].BuySignal
+			if crossed && -bar.Diff > minBuySignal {
+				candidates = append(candidates, j)
+			}
+		} else if bar.Diff > minBuySignal && bar.BuySignal {
+			candidates = append(candidates, j)
+		}
+	}
+	strength := func(j int) float32 {
+		if short {
+			return -market.Bar(j).DiffAdjusted
+		}
+		return market.Bar(j).DiffAdjusted
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		diffA := strength(candidates[a])
+		diffB := strength(candidates[b])
+		if diffA == diffB {
+			return candidates[a] > candidates[b] // ties go to the later symbol
+		}
+		return diffA > diffB
+	})
+	return candidates
+}
+
+// buy adds an order for as many shares of symbol j as dollars buy at its current price
+func (s *MACD) buy(orders []Order, market *MarketState, j int, dollars decimal.Decimal) []Order {
+	price := market.Bar(j).Price
+	symbol := market.Symbol(j)
+	lot := s.Lots.For(symbol)
+	at := lot.Price(price)
+	if at <= 0 {
+		return orders
+	}
+	canBuy := lot.Quantity(dollars.Div(at))
+	if canBuy <= 0 {
+		return orders
+	}
+
+	orders = append(orders, Order{
+		Symbol:   symbol,
+		Buy:      true,
+		Quantity: canBuy,
+		Price:    at,
+	}.Priced(s.Orders, lot))
+	_, closing := s.closing[symbol]
+	if _, ok := s.entries[symbol]; !ok || closing {
+		s.entries[symbol] = price
+	}
+	s.Exits.Open(symbol, market.History(j), false)
+
+	if s.StopAtSupport || s.TargetResistance {
+		levels := indicators.CalculateLevels(market.History(j))
+		s.stops[symbol] = levels.Support
+		s.targets[symbol] = levels.Resistance
+	}
+	return orders
+}
+
+// sellShort adds an order to sell short as many shares of symbol j as are worth dollars at its current price
+func (s *MACD) sellShort(orders []Order, market *MarketState, j int, dollars decimal.Decimal) []Order {
+	price := market.Bar(j).Price
+	symbol := market.Symbol(j)
+	lot := s.Lots.For(symbol)
+	at := lot.Price(price)
+	if at <= 0 {
+		return orders
+	}
+	quantity := lot.Quantity(dollars.Div(at))
+	if quantity <= 0 {
+		return orders
+	}
+
+	orders = append(orders, Order{
+		Symbol:   symbol,
+		Buy:      false,
+		Quantity: quantity,
+		Price:    at,
+		Short:    true,
+	}.Priced(s.Orders, lot))
+	s.entries[symbol] = price
+	s.Exits.Open(symbol, market.History(j), true)
+	return orders
+}
@@ -0,0 +1,197 @@
+package strategy
+
+import (
+	"math"
+
+	"github.com/mcmohorn/market/server/analyzer"
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+)
+
+// Pairs trades the spreads of cointegrated pairs. Once it has seen FormationIntervals bars it finds the pairs
+// on them and splits the portfolio evenly between them. It goes long a pair's spread (long First and short
+// Second, split by the hedge ratio) when its z-score falls below -EntryZ and short it above EntryZ, closing
+// it once back within ExitZ or beyond StopZ against it. A symbol is only ever in one open pair at a time.
+type Pairs struct {
+	Options data.PairsOptions
+	Lots    data.Lots         // how finely each symbol can be traded, whole shares by default
+	Orders  data.OrderOptions // kind of orders placed, market orders by default
+
+	scanned    bool
+	pairs      []data.Pair
+	sides      []int           // of each pair, 1 when long its spread, -1 when short it and 0 when flat
+	allocation decimal.Decimal // of the portfolio for each pair
+}
+
+// NewPairs creates the pairs strategy configured for a simulation
+func NewPairs(opts *data.PairsOptions) *Pairs {
+	return &Pairs{
+		Options: *opts,
+		Lots:    opts.Simulation.Lots,
+		Orders:  opts.Simulation.Orders,
+	}
+}
+
+func (s *Pairs) Name() string {
+	return "pairs"
+}
+
+func (s *Pairs) OnBar(market *MarketState, portfolio *Portfolio) []Order {
+	orders := make([]Order, 0)
+	if !s.scanned && !s.scan(market, portfolio) {
+		return orders
+	}
+
+	busy := make(map[string]bool)
+	for p, pair := range s.pairs {
+		if s.sides[p] != 0 {
+			busy[pair.First] = true
+			busy[pair.Second] = true
+		}
+	}
+
+	for p, pair := range s.pairs {
+		i, j := market.Find(pair.First), market.Find(pair.Second)
+		if i < 0 || j < 0 || !market.Has(i) || !market.Has(j) {
+			continue
+		}
+		first, second := portfolio.Shares[pair.First], portfolio.Shares[pair.Second]
+		z, ok := s.zScore(market, pair, i, j)
+
+		if side := s.sides[p]; side != 0 {
+			if portfolio.Pending[pair.First] || portfolio.Pending[pair.Second] {
+				// wait for its orders to fill or expire
+				continue
+			}
+			if first == 0 && second == 0 {
+				// closed, or its orders never filled, it can open again below
+				s.sides[p] = 0
+			} else {
+				reason := ""
+				switch {
+				case first == 0 || second == 0:
+					reason = ExitLeg
+				case !ok:
+				case math.Abs(z) < s.Options.ExitZ:
+					reason = ExitSignal
+				case s.Options.StopZ > 0 && float64(side)*z < -s.Options.StopZ:
+					reason = ExitStop
+				}
+				if reason != "" {
+					orders = s.close(orders, market, i, first, reason)
+					orders = s.close(orders, market, j, second, reason)
+				}
+				continue
+			}
+		}
+
+		if !ok || busy[pair.First] || busy[pair.Second] || first != 0 || second != 0 || portfolio.Pending[pair.First] || portfolio.Pending[pair.Second] {
+			continue
+		}
+		side := 0
+		if z > s.Options.EntryZ {
+			side = -1
+		} else if z < -s.Options.EntryZ {
+			side = 1
+		}
+		if side == 0 {
+			continue
+		}
+
+		// split the allocation between the legs by the hedge ratio
+		firstDollars := s.allocation.Div(decimal.NewFromFloat(1 + pair.HedgeRatio))
+		firstOrder, firstOk := s.open(market, i, firstDollars, side == 1)
+		secondOrder, secondOk := s.open(market, j, s.allocation-firstDollars, side == -1)
+		if !firstOk || !secondOk {
+			continue
+		}
+		orders = append(orders, firstOrder, secondOrder)
+		s.sides[p] = side
+		busy[pair.First] = true
+		busy[pair.Second] = true
+	}
+	return orders
+}
+
+// scan finds the pairs on the FormationIntervals bars before the current one of every symbol that has them,
+// telling whether there were enough symbols with enough bars to look at yet
+func (s *Pairs) scan(market *MarketState, portfolio *Portfolio) bool {
+	formation := s.Options.FormationIntervals
+	window := make([]data.SymbolData, 0, market.Len())
+	for k := 0; k < market.Len(); k++ {
+		if !market.Has(k) {
+			continue
+		}
+		history := market.History(k)
+		if len(history) <= formation {
+			continue
+		}
+		window = append(window, data.SymbolData{Symbol: market.Symbol(k), Bars: history[len(history)-1-formation : len(history)-1]})
+	}
+	if len(window) < 2 {
+		return false
+	}
+
+	s.scanned = true
+	s.pairs = analyzer.ScanPairs(window, 0, formation, &s.Options)
+	if s.Options.MaxPairs > 0 && len(s.pairs) > s.Options.MaxPairs {
+		s.pairs = s.pairs[:s.Options.MaxPairs]
+	}
+	s.sides = make([]int, len(s.pairs))
+	if len(s.pairs) > 0 {
+		s.allocation = market.Value(portfolio).Div(decimal.New(int64(len(s.pairs))))
+	}
+	return true
+}
+
+// zScore is the z-score of a pair's spread on the current bar against the ZLookback bars up to it
+func (s *Pairs) zScore(market *MarketState, pair data.Pair, i int, j int) (float64, bool) {
+	lookback := s.Options.ZLookback
+	first, second := market.History(i), market.History(j)
+	if lookback < 2 || len(first) < lookback || len(second) < lookback {
+		return 0, false
+	}
+	y := analyzer.LogPrices(first[len(first)-lookback:])
+	x := analyzer.LogPrices(second[len(second)-lookback:])
+	if y == nil || x == nil {
+		return 0, false
+	}
+	return analyzer.SpreadZScores(y, x, pair.HedgeRatio, pair.Intercept, lookback)[lookback-1], true
+}
+
+// open returns an order buying, or selling short, as much of symbol j as dollars get at its current price
+func (s *Pairs) open(market *MarketState, j int, dollars decimal.Decimal, buy bool) (Order, bool) {
+	symbol := market.Symbol(j)
+	lot := s.Lots.For(symbol)
+	price := lot.Price(market.Bar(j).Price)
+	if price <= 0 {
+		return Order{}, false
+	}
+	quantity := lot.Quantity(dollars.Div(price))
+	if quantity <= 0 {
+		return Order{}, false
+	}
+	return Order{
+		Symbol:   symbol,
+		Buy:      buy,
+		Quantity: quantity,
+		Price:    price,
+		Short:    !buy,
+	}.Priced(s.Orders, lot), true
+}
+
+// close adds an order selling the shares held of symbol j, or buying back the ones we are short
+func (s *Pairs) close(orders []Order, market *MarketState, j int, shares decimal.Decimal, reason string) []Order {
+	if shares == 0 {
+		return orders
+	}
+	symbol := market.Symbol(j)
+	lot := s.Lots.For(symbol)
+	return append(orders, Order{
+		Symbol:   symbol,
+		Buy:      shares < 0,
+		Quantity: shares.Abs(),
+		Price:    lot.Price(market.Bar(j).Price),
+		Reason:   reason,
+	}.Priced(s.Orders, lot))
+}
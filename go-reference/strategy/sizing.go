@@ -0,0 +1,104 @@
+package strategy
+
+import (
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+	"github.com/mcmohorn/market/server/indicators"
+)
+
+// kellyMinTrades is how many closed trades kelly sizing needs before trusting its own statistics
+const kellyMinTrades = 10
+
+// defaultKellyFraction is the part of the full kelly bet made when none is given, half kelly
+const defaultKellyFraction = 0.5
+
+// Sizer decides how much of the portfolio goes into each new position
+type Sizer struct {
+	Options data.SizingOptions
+	returns []float32 // of the closed trades, for kelly sizing
+}
+
+// MaxPositions is how many positions may be held at once, 0 for no limit
+func (z *Sizer) MaxPositions() int {
+	if z.Options.Method == data.AllIn {
+		return 1
+	}
+	return z.Options.MaxPositions
+}
+
+// Record adds the return of a closed trade to the history kelly sizing learns from
+func (z *Sizer) Record(r float32) {
+	z.returns = append(z.returns, r)
+}
+
+// Dollars is how much to put into a new position in a symbol with the given history at price, out of a
+// portfolio worth equity. It is not limited to the cash available.
+func (z *Sizer) Dollars(equity decimal.Decimal, history []data.MyBar, price float32) decimal.Decimal {
+	opts := z.Options
+	dollars := equity
+	switch opts.Method {
+	case data.FixedFraction:
+		dollars = equity.Mul(decimal.NewFromFloat32(opts.Fraction))
+	case data.EqualWeight:
+		slots := opts.Slots
+		if slots <= 0 {
+			slots = opts.MaxPositions
+		}
+		if slots > 0 {
+			dollars = equity.Div(decimal.New(int64(slots)))
+		}
+	case data.VolatilityTarget:
+		period := opts.ATRPeriod
+		if period <= 0 {
+			period = indicators.DefaultATRPeriod
+		}
+		atr := indicators.ATR(history, period)
+		if atr <= 0 {
+			return 0
+		}
+		dollars = equity.Mul(decimal.NewFromFloat32(opts.TargetRisk / atr * price))
+	case data.Kelly:
+		dollars = equity.Mul(decimal.NewFromFloat32(opts.Fraction))
+		if len(z.returns) >= kellyMinTrades {
+			fraction := opts.KellyFraction
+			if fraction <= 0 {
+				fraction = defaultKellyFraction
+			}
+			dollars = equity.Mul(decimal.NewFromFloat32(fraction * z.kelly()))
+		}
+	}
+
+	if most := equity.Mul(decimal.NewFromFloat32(opts.MaxPositionPercent / 100)); opts.MaxPositionPercent > 0 && dollars > most {
+		dollars = most
+	}
+	if dollars < 0 {
+		return 0
+	}
+	return dollars
+}
+
+// kelly is the fraction of the portfolio the kelly criterion bets given the closed trades, W - (1 - W) / R
+// where W is the fraction of trades won and R the average win over the average loss
+func (z *Sizer) kelly() float32 {
+	wins := 0
+	totalWin := float32(0)
+	totalLoss := float32(0)
+	for _, r := range z.returns {
+		if r > 0 {
+			wins++
+			totalWin += r
+		} else {
+			totalLoss -= r
+		}
+	}
+	losses := len(z.returns) - wins
+	if wins == 0 {
+		return 0
+	}
+	if losses == 0 || totalLoss == 0 {
+		return 1
+	}
+	w := float32(wins) / float32(len(z.returns))
+	ratio := (totalWin / float32(wins)) / (totalLoss / float32(losses))
+	return w - (1-w)/ratio
+}
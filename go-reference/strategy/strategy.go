@@ -0,0 +1,162 @@
+// Package strategy holds the trading rules shared by the simulator and the live day trader
+package strategy
+
+import (
+	"sort"
+
+	"github.com/mcmohorn/market/server/data"
+	"github.com/mcmohorn/market/server/decimal"
+)
+
+// Strategy decides on each bar what to buy and sell given the market and what we hold
+type Strategy interface {
+	Name() string
+	OnBar(market *MarketState, portfolio *Portfolio) []Order
+}
+
+// Order is an instruction from a strategy to buy or sell a quantity of a symbol
+type Order struct {
+	Symbol   string
+	Buy      bool
+	Quantity decimal.Decimal
+	Price    decimal.Decimal // price the strategy expects to trade at, used as the limit price when trading live
+	Short    bool            // a sell of borrowed shares, which can go beyond the shares held
+	Reason   string          // why a position is closed (see ExitSignal and the others), empty when opening one
+	Type     data.OrderType
+	Limit    decimal.Decimal // price a limit order fills at or better (limit and stop limit orders)
+	Stop     decimal.Decimal // price that turns a stop order into a market or limit order (stop and stop limit orders)
+	Expiry   int             // bars of its symbol the order waits to fill before it is cancelled, 1 when 0
+}
+
+// Priced returns the order with the type, prices and expiry opts describe, placed from its Price and
+// rounded to lot's tick
+func (o Order) Priced(opts data.OrderOptions, lot data.LotSize) Order {
+	// offsets are against us, above the price for buys and below it for sells
+	away := func(price decimal.Decimal, percent float32) decimal.Decimal {
+		if !o.Buy {
+			percent = -percent
+		}
+		return lot.RoundPrice(price.Mul(decimal.NewFromFloat(1 + float64(percent)/100)))
+	}
+	o.Type = opts.Type
+	o.Expiry = opts.Expiry
+	switch opts.Type {
+	case data.LimitOrder:
+		o.Limit = away(o.Price, opts.LimitOffset)
+	case data.StopOrder:
+		o.Stop = away(o.Price, opts.StopOffset)
+	case data.StopLimitOrder:
+		o.Stop = away(o.Price, opts.StopOffset)
+		o.Limit = away(o.Stop, opts.LimitOffset)
+	}
+	return o
+}
+
+// Portfolio is the cash and shares a strategy has to work with
+type Portfolio struct {
+	Cash    decimal.Decimal
+	Shares  map[string]decimal.Decimal // negative for short positions
+	Pending map[string]bool            // symbols with orders placed on an earlier bar that may still fill
+}
+
+// Symbols returns the symbols the portfolio has shares of in alphabetical order, so that going through them
+// does not depend on the order of the map
+func (p *Portfolio) Symbols() []string {
+	symbols := make([]string, 0, len(p.Shares))
+	for symbol, num := range p.Shares {
+		if num != 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// MarketState is what a strategy can see of the market on the bar it is deciding on. When backtesting
+// Data only holds the bars up to the current time.
+type MarketState struct {
+	Data    []data.SymbolData
+	Index   int   // bar index shared by all (aligned) symbols, or -1 for each symbol's latest bar
+	Indexes []int // bar index of each symbol at the current time (-1 if it has no bar then), used instead of Index when set
+	Breadth data.Breadth
+	Regime  data.Regime
+}
+
+// Len is the number of symbols in the market
+func (m *MarketState) Len() int {
+	return len(m.Data)
+}
+
+// Symbol returns the symbol at index i
+func (m *MarketState) Symbol(i int) string {
+	return m.Data[i].Symbol
+}
+
+// Find returns the index of the given symbol, or -1 if it is not in the market
+func (m *MarketState) Find(symbol string) int {
+	for i, s := range m.Data {
+		if s.Symbol == symbol {
+			return i
+		}
+	}
+	return -1
+}
+
+// Has tells whether symbol i has a bar at the current time
+func (m *MarketState) Has(i int) bool {
+	return m.index(i) >= 0
+}
+
+// Bar returns the current bar of symbol i, which must have one (see Has)
+func (m *MarketState) Bar(i int) data.MyBar {
+	return m.Data[i].Bars[m.index(i)]
+}
+
+// History returns the bars of symbol i up to and including the current one
+func (m *MarketState) History(i int) []data.MyBar {
+	return m.Data[i].Bars[:m.index(i)+1]
+}
+
+// LastPrice is the price of symbol i on its latest bar up to the current time, 0 if it has none
+func (m *MarketState) LastPrice(i int) float32 {
+	if m.Has(i) {
+		return m.Bar(i).Price
+	}
+	if bars := m.Data[i].Bars; len(bars) > 0 && m.Indexes != nil {
+		// a backtest only shows bars up to the current time
+		return bars[len(bars)-1].Price
+	}
+	return 0
+}
+
+// Value is the cash plus every holding of the portfolio at its last price
+func (m *MarketState) Value(portfolio *Portfolio) decimal.Decimal {
+	total := portfolio.Cash
+	for symbol, num := range portfolio.Shares {
+		if i := m.Find(symbol); i >= 0 {
+			total = total + num.Mul(decimal.NewFromFloat32(m.LastPrice(i)))
+		}
+	}
+	return total
+}
+
+// ShortValue is what it would cost to buy back every short position of the portfolio at its last price
+func (m *MarketState) ShortValue(portfolio *Portfolio) decimal.Decimal {
+	total := decimal.Zero
+	for symbol, num := range portfolio.Shares {
+		if i := m.Find(symbol); i >= 0 && num < 0 {
+			total = total - num.Mul(decimal.NewFromFloat32(m.LastPrice(i)))
+		}
+	}
+	return total
+}
+
+func (m *MarketState) index(i int) int {
+	if m.Indexes != nil {
+		return m.Indexes[i]
+	}
+	if m.Index < 0 {
+		return len(m.Data[i].Bars) - 1
+	}
+	return m.Index
+}